@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// branchUsage is printed when `branch` is called without a known subcommand.
+const branchUsage = `usage: parallel-git-repo branch create NAME [--from REF] [--partial]
+       parallel-git-repo branch switch NAME [--partial]
+       parallel-git-repo branch delete NAME [--force] [--partial]
+       parallel-git-repo branch list [--common]`
+
+// operation is a bulk change guarded by preflight checks: check tells whether
+// a repository can take part, act then performs the change.
+type operation struct {
+	check func(ctx context.Context, repo string) error
+	act   func(ctx context.Context, repo string) error
+}
+
+// branchCommand implements the `branch` family. Running the configured
+// `checkout` over a group leaves it half-switched as soon as a few
+// repositories have local changes; these subcommands check every repository
+// first and change none of them unless all pass (or --partial is given).
+func branchCommand(config *configuration, args []string, group string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, branchUsage)
+		return 1
+	}
+
+	fs := flag.NewFlagSet("branch "+args[0], flag.ContinueOnError)
+	from := fs.String("from", "HEAD", "create the branch at this ref")
+	force := fs.Bool("force", false, "delete the branch even if it is not merged")
+	common := fs.Bool("common", false, "only list branches present in every repository")
+	partial := fs.Bool("partial", false, "act on the repositories that pass the preflight checks even if others fail them")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return 1
+	}
+
+	runner := configuredRunner(nil, config)
+	runner.commandNames = []string{"branch"}
+	runner.writes = true
+	if args[0] == "list" {
+		return runner.ListBranches(group, *common)
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, branchUsage)
+		return 1
+	}
+	name := positional[0]
+
+	var op operation
+	switch args[0] {
+	case "create":
+		op = runner.createBranch(name, *from)
+	case "switch":
+		op = runner.switchBranch(name)
+	case "delete":
+		op = runner.deleteBranch(name, *force)
+	default:
+		fmt.Fprintln(os.Stderr, branchUsage)
+		return 1
+	}
+	return runner.Branch(op, group, *partial)
+}
+
+func (runner *runner) createBranch(name, from string) operation {
+	return operation{
+		check: func(ctx context.Context, repo string) error {
+			if _, err := runner.git(ctx, repo, "check-ref-format", "--branch", name); err != nil {
+				return fmt.Errorf("%q is not a valid branch name", name)
+			}
+			if runner.hasBranch(ctx, repo, name) {
+				return fmt.Errorf("branch %s already exists", name)
+			}
+			if _, err := runner.git(ctx, repo, "rev-parse", "--verify", "--quiet", from+"^{commit}"); err != nil {
+				return fmt.Errorf("cannot resolve %s", from)
+			}
+			return nil
+		},
+		act: func(ctx context.Context, repo string) error {
+			_, err := runner.git(ctx, repo, "branch", name, from)
+			return err
+		},
+	}
+}
+
+func (runner *runner) switchBranch(name string) operation {
+	return operation{
+		check: func(ctx context.Context, repo string) error {
+			if err := runner.checkClean(ctx, repo); err != nil {
+				return err
+			}
+			// git switch also creates a local branch tracking origin/NAME.
+			if !runner.hasBranch(ctx, repo, name) && !runner.hasRef(ctx, repo, "refs/remotes/origin/"+name) {
+				return fmt.Errorf("branch %s does not exist", name)
+			}
+			return nil
+		},
+		act: func(ctx context.Context, repo string) error {
+			_, err := runner.git(ctx, repo, "switch", name)
+			return err
+		},
+	}
+}
+
+func (runner *runner) deleteBranch(name string, force bool) operation {
+	return operation{
+		check: func(ctx context.Context, repo string) error {
+			if !runner.hasBranch(ctx, repo, name) {
+				return fmt.Errorf("branch %s does not exist", name)
+			}
+			if current, _ := runner.git(ctx, repo, "symbolic-ref", "--short", "--quiet", "HEAD"); current == name {
+				return fmt.Errorf("branch %s is checked out", name)
+			}
+			// Mirror `git branch -d`: merged into HEAD or into its upstream.
+			if !force && !runner.isAncestor(ctx, repo, name, "HEAD") && !runner.isAncestor(ctx, repo, name, name+"@{upstream}") {
+				return fmt.Errorf("branch %s is not fully merged, use --force to delete it anyway", name)
+			}
+			return nil
+		},
+		act: func(ctx context.Context, repo string) error {
+			_, err := runner.git(ctx, repo, "branch", "-D", name)
+			return err
+		},
+	}
+}
+
+// Branch applies a branch operation to the selected repositories.
+func (runner *runner) Branch(op operation, group string, partial bool) int {
+	repos, err := runner.selected(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return runner.apply(op, repos, partial)
+}
+
+// apply checks every repository, then acts on them. When any repository fails
+// its check nothing is changed, unless partial is set in which case only the
+// repositories that passed are changed.
+func (runner *runner) apply(op operation, repos []string, partial bool) int {
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		errs[i] = op.check(ctx, repo)
+	})
+
+	var ready []string
+	for i, repo := range repos {
+		if errs[i] != nil {
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		ready = append(ready, repo)
+	}
+	refused := len(repos) - len(ready)
+	if refused > 0 && !partial {
+		fmt.Fprintf(runner.writer, "\nNo repository changed: %d of %d failed the preflight checks, fix them or use --partial.\n", refused, len(repos))
+		return refused
+	}
+
+	failures := make([]error, len(ready))
+	runner.each(ready, func(ctx context.Context, i int, repo string) {
+		failures[i] = op.act(ctx, repo)
+	})
+	failed := refused
+	for i, repo := range ready {
+		if failures[i] != nil {
+			failed++
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, failures[i])
+			continue
+		}
+		fmt.Fprintf(runner.writer, "%s: %s\n", filepath.Base(repo), ok)
+	}
+	return failed
+}
+
+// ListBranches prints every local branch with the number of repositories
+// having it, or with common only the branches every repository has.
+func (runner *runner) ListBranches(group string, common bool) int {
+	repos, err := runner.selection(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	branches := make([][]string, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		var out string
+		out, errs[i] = runner.git(ctx, repo, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+		if out != "" {
+			branches[i] = strings.Split(out, "\n")
+		}
+	})
+
+	failures := 0
+	counts := make(map[string]int)
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(os.Stderr, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		for _, branch := range branches[i] {
+			counts[branch]++
+		}
+	}
+	total := len(repos) - failures
+
+	width := 0
+	for branch := range counts {
+		if n := len(branch); n > width {
+			width = n
+		}
+	}
+	for _, branch := range sortedKeys(counts) {
+		if common {
+			if counts[branch] == total {
+				fmt.Fprintln(runner.writer, branch)
+			}
+			continue
+		}
+		fmt.Fprintf(runner.writer, "%-*s  %d/%d\n", width, branch, counts[branch], total)
+	}
+	return failures
+}
+
+// defaultBranch detects the branch a repository integrates into: the one
+// origin/HEAD points to, else Git's init.defaultBranch, else main or master,
+// provided the branch exists locally.
+func (runner *runner) defaultBranch(ctx context.Context, repo string) (string, error) {
+	if ref, err := runner.git(ctx, repo, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return strings.TrimPrefix(ref, "origin/"), nil
+	}
+	candidates := []string{"main", "master"}
+	if configured, _ := runner.git(ctx, repo, "config", "--get", "init.defaultBranch"); configured != "" {
+		candidates = append([]string{configured}, candidates...)
+	}
+	for _, name := range candidates {
+		if runner.hasBranch(ctx, repo, name) {
+			return name, nil
+		}
+	}
+	return "", errors.New("cannot detect the default branch")
+}
+
+func (runner *runner) hasBranch(ctx context.Context, repo, name string) bool {
+	return runner.hasRef(ctx, repo, "refs/heads/"+name)
+}
+
+func (runner *runner) hasRef(ctx context.Context, repo, ref string) bool {
+	_, err := runner.git(ctx, repo, "show-ref", "--verify", "--quiet", ref)
+	return err == nil
+}
+
+func (runner *runner) isAncestor(ctx context.Context, repo, ancestor, descendant string) bool {
+	_, err := runner.git(ctx, repo, "merge-base", "--is-ancestor", ancestor, descendant)
+	return err == nil
+}
+
+// checkClean fails when tracked files have local changes, which a checkout
+// would either carry over or refuse to overwrite.
+func (runner *runner) checkClean(ctx context.Context, repo string) error {
+	out, err := runner.git(ctx, repo, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		return errors.New("worktree has local changes")
+	}
+	return nil
+}
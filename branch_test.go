@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBranchSwitchRefusesEveryRepositoryWhenOneIsDirty(t *testing.T) {
+	clean := gitRepository(t, "clean", map[string]string{"a.txt": "a"})
+	dirty := gitRepository(t, "dirty", map[string]string{"a.txt": "a"})
+	for _, repo := range []string{clean, dirty} {
+		git(t, repo, "branch", "feature")
+	}
+	os.WriteFile(filepath.Join(dirty, "a.txt"), []byte("changed"), 0644)
+	runner, output := testRunner(nil, staticRepositories{"default": {clean, dirty}})
+
+	if failures := runner.Branch(runner.switchBranch("feature"), "default", false); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+	assertEqual(t, git(t, clean, "branch", "--show-current"), "main")
+	if !strings.Contains(output.String(), "worktree has local changes") {
+		t.Errorf("expected the preflight failure to be reported, got %q", output.String())
+	}
+
+	// --partial switches the repositories that passed.
+	runner.Branch(runner.switchBranch("feature"), "default", true)
+	assertEqual(t, git(t, clean, "branch", "--show-current"), "feature")
+	assertEqual(t, git(t, dirty, "branch", "--show-current"), "main")
+}
+
+func TestBranchCreateChecksNameAndRef(t *testing.T) {
+	first := gitRepository(t, "first", nil)
+	second := gitRepository(t, "second", nil)
+	git(t, second, "branch", "release")
+	runner, _ := testRunner(nil, staticRepositories{"default": {first, second}})
+
+	// release already exists in second, so first is left untouched too.
+	if failures := runner.Branch(runner.createBranch("release", "HEAD"), "default", false); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+	if runner.hasBranch(t.Context(), first, "release") {
+		t.Error("release was created although a preflight check failed")
+	}
+
+	if failures := runner.Branch(runner.createBranch("feature", "does-not-exist"), "default", false); failures != 2 {
+		t.Errorf("got %d failures for an unknown ref, want 2", failures)
+	}
+
+	if failures := runner.Branch(runner.createBranch("feature", "main"), "default", false); failures != 0 {
+		t.Errorf("got %d failures, want 0", failures)
+	}
+	if !runner.hasBranch(t.Context(), first, "feature") || !runner.hasBranch(t.Context(), second, "feature") {
+		t.Error("feature should exist in every repository")
+	}
+}
+
+func TestBranchDeleteRefusesUnmergedBranch(t *testing.T) {
+	repo := gitRepository(t, "repo", nil)
+	git(t, repo, "switch", "-q", "-c", "wip")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "unmerged")
+	git(t, repo, "switch", "-q", "main")
+	runner, _ := testRunner(nil, staticRepositories{"default": {repo}})
+
+	if failures := runner.Branch(runner.deleteBranch("wip", false), "default", false); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+	if failures := runner.Branch(runner.deleteBranch("wip", true), "default", false); failures != 0 {
+		t.Errorf("got %d failures with force, want 0", failures)
+	}
+	if runner.hasBranch(t.Context(), repo, "wip") {
+		t.Error("wip should have been deleted")
+	}
+}
+
+func TestListBranches(t *testing.T) {
+	first := gitRepository(t, "first", nil)
+	second := gitRepository(t, "second", nil)
+	git(t, first, "branch", "only-first")
+	runner, output := testRunner(nil, staticRepositories{"default": {first, second}})
+
+	runner.ListBranches("default", false)
+	assertEqual(t, output.String(), "main        2/2\nonly-first  1/2\n")
+
+	output.Reset()
+	runner.ListBranches("default", true)
+	assertEqual(t, output.String(), "main\n")
+}
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	from := fs.String("from", "HEAD", "")
+	positional, err := parseInterspersed(fs, []string{"create", "feature", "--from", "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(positional, ","), "create,feature")
+	assertEqual(t, *from, "main")
+}
+
+func TestDefaultBranch(t *testing.T) {
+	runner := newRunner(nil, staticRepositories{})
+
+	repo := gitRepository(t, "repo", nil)
+	git(t, repo, "branch", "-m", "main", "master")
+	if got, err := runner.defaultBranch(t.Context(), repo); err != nil || got != "master" {
+		t.Errorf("got %q, %v, want master", got, err)
+	}
+
+	git(t, repo, "branch", "develop")
+	git(t, repo, "config", "init.defaultBranch", "develop")
+	if got, _ := runner.defaultBranch(t.Context(), repo); got != "develop" {
+		t.Errorf("got %q, want the configured develop", got)
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	git(t, repo, "clone", "-q", repo, clone)
+	git(t, clone, "switch", "-q", "-c", "main")
+	if got, _ := runner.defaultBranch(t.Context(), clone); got != "master" {
+		t.Errorf("got %q, want master from origin/HEAD", got)
+	}
+
+	if _, err := runner.defaultBranch(t.Context(), t.TempDir()); err == nil {
+		t.Error("expected an error outside of a repository")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decoders read the configuration formats other than TOML, chosen by file
+// extension. Their documents are converted to the tree a TOML file produces,
+// so every format shares one model.
+var decoders = map[string]func([]byte, interface{}) error{
+	".json": json.Unmarshal,
+	".yaml": yaml.Unmarshal,
+	".yml":  yaml.Unmarshal,
+}
+
+// loadTree reads a configuration file in the format given by its extension,
+// TOML by default.
+func loadTree(file string) (*toml.Tree, error) {
+	decode, ok := decoders[strings.ToLower(filepath.Ext(file))]
+	if !ok {
+		return toml.LoadFile(file)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// An empty file is an empty tree whatever its format, though encoding/json
+	// rejects an empty document: document stays a nil map, converted below.
+	var document map[string]interface{}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err := decode(content, &document); err != nil {
+			return nil, err
+		}
+	}
+	tree, _ := treeValue(document).(*toml.Tree)
+	return tree, nil
+}
+
+// treeValue converts a decoded JSON or YAML value to what go-toml yields for
+// the same TOML: tables become trees, arrays of tables []*toml.Tree and
+// integers int64, JSON numbers included.
+func treeValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		tree, _ := toml.TreeFromMap(map[string]interface{}{})
+		for key, child := range value {
+			tree.SetPath([]string{key}, treeValue(child))
+		}
+		return tree
+	case []interface{}:
+		values := make([]interface{}, len(value))
+		tables := make([]*toml.Tree, 0, len(value))
+		for i, child := range value {
+			values[i] = treeValue(child)
+			if table, ok := values[i].(*toml.Tree); ok {
+				tables = append(tables, table)
+			}
+		}
+		if len(value) > 0 && len(tables) == len(value) {
+			return tables
+		}
+		return values
+	case int:
+		return int64(value)
+	case float64:
+		if value == math.Trunc(value) {
+			return int64(value)
+		}
+	}
+	return value
+}
+
+// marshalConfiguration writes tree back in the format of file.
+func marshalConfiguration(file string, tree *toml.Tree) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		out, err := json.MarshalIndent(plainValue(tree), "", "  ")
+		return append(out, '\n'), err
+	case ".yaml", ".yml":
+		return yaml.Marshal(plainValue(tree))
+	}
+	out, err := tree.ToTomlString()
+	return []byte(out), err
+}
+
+// plainValue is the inverse of treeValue, for the JSON and YAML encoders.
+func plainValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *toml.Tree:
+		values := make(map[string]interface{})
+		for _, key := range value.Keys() {
+			values[key] = plainValue(value.GetPath([]string{key}))
+		}
+		return values
+	case []*toml.Tree:
+		values := make([]interface{}, len(value))
+		for i, child := range value {
+			values[i] = plainValue(child)
+		}
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(value))
+		for i, child := range value {
+			values[i] = plainValue(child)
+		}
+		return values
+	}
+	return value
+}
+
+// loadConfiguration reads file and the files listed in its `include` array,
+// relative to file unless absolute or starting with ~. Included files are
+// merged in order, then file itself, each one overriding the previous: later
+// tables and values win and a command is replaced as a whole, while groups
+// listed in several files are concatenated, unless file sets
+// `merge_groups = "replace"`.
+func loadConfiguration(file string, including map[string]bool) (*toml.Tree, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if including[abs] {
+		return nil, fmt.Errorf("%s includes itself", file)
+	}
+	including[abs] = true
+	defer delete(including, abs)
+
+	tree, err := loadTree(file)
+	if err != nil {
+		return nil, err
+	}
+	resolveRepositories(tree, filepath.Dir(abs))
+	includes, _ := tree.Get("include").([]interface{})
+	if len(includes) == 0 {
+		return tree, nil
+	}
+
+	replaceGroups := tree.Get("merge_groups") == "replace"
+	merged, _ := toml.TreeFromMap(map[string]interface{}{})
+	for _, include := range toStringArray(includes) {
+		path, err := homedir.Expand(include)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		included, err := loadConfiguration(path, including)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", include, err)
+		}
+		mergeConfiguration(merged, included, replaceGroups)
+	}
+	mergeConfiguration(merged, tree, replaceGroups)
+	return merged, nil
+}
+
+func mergeConfiguration(dst, src *toml.Tree, replaceGroups bool) {
+	for _, key := range src.Keys() {
+		switch key {
+		case "include", "merge_groups":
+		case "repositories":
+			mergeGroups(dst, src, replaceGroups)
+		case "commands":
+			mergeTables(dst, src, key, false)
+		default:
+			mergeTables(dst, src, key, true)
+		}
+	}
+}
+
+// mergeTables sets dst[key] to src[key]. When both are tables, their keys are
+// merged one by one, recursively if deep.
+func mergeTables(dst, src *toml.Tree, key string, deep bool) {
+	value := src.GetPath([]string{key})
+	from, isTable := value.(*toml.Tree)
+	into, wasTable := dst.GetPath([]string{key}).(*toml.Tree)
+	if !isTable || !wasTable {
+		dst.SetPath([]string{key}, value)
+		return
+	}
+	for _, child := range from.Keys() {
+		if deep {
+			mergeTables(into, from, child, true)
+		} else {
+			into.SetPath([]string{child}, from.GetPath([]string{child}))
+		}
+	}
+}
+
+// mergeGroups appends the repositories of src's groups to dst's. An entry for
+// a path already listed replaces the earlier one in place, so a later file can
+// add attributes to a shared repository.
+func mergeGroups(dst, src *toml.Tree, replace bool) {
+	groups, ok := src.Get("repositories").(*toml.Tree)
+	if !ok {
+		return
+	}
+	for _, group := range groups.Keys() {
+		path := []string{"repositories", group}
+		entries := repositoryEntries(groups.GetPath([]string{group}))
+		if replace || dst.GetPath(path) == nil {
+			dst.SetPath(path, entries)
+			continue
+		}
+		merged := repositoryEntries(dst.GetPath(path))
+		for _, entry := range entries {
+			i := slices.IndexFunc(merged, func(existing interface{}) bool { return repositoryPath(existing) == repositoryPath(entry) })
+			if i < 0 {
+				merged = append(merged, entry)
+			} else {
+				merged[i] = entry
+			}
+		}
+		dst.SetPath(path, merged)
+	}
+}
+
+// resolveRepositories expands the repository paths of a configuration file:
+// environment variables ($HOME, ${WORKSPACE}…) and a leading ~ are replaced,
+// and relative paths are made absolute against the file's directory, so a
+// shared config need not hardcode one machine's home or checkout location.
+func resolveRepositories(tree *toml.Tree, dir string) {
+	groups, ok := tree.Get("repositories").(*toml.Tree)
+	if !ok {
+		return
+	}
+	for _, group := range groups.Keys() {
+		entries := repositoryEntries(groups.GetPath([]string{group}))
+		for i, entry := range entries {
+			switch entry := entry.(type) {
+			case string:
+				entries[i] = resolvePath(entry, dir)
+			case *toml.Tree:
+				if path, ok := entry.Get("path").(string); ok {
+					entry.Set("path", resolvePath(path, dir))
+				}
+			}
+		}
+		if entries != nil {
+			groups.SetPath([]string{group}, entries)
+		}
+	}
+}
+
+// resolvePath expands ~ and the environment variables of a configured path and
+// resolves it against dir when relative. A path using an unset variable is
+// returned as written rather than leading to the wrong directory, as
+// ${WORKSPACE}/tools would to /tools.
+func resolvePath(path, dir string) string {
+	unset := false
+	expanded := os.Expand(path, func(name string) string {
+		value, found := os.LookupEnv(name)
+		unset = unset || !found
+		return value
+	})
+	if unset {
+		return path
+	}
+	path = expanded
+	if expanded, err := homedir.Expand(path); err == nil {
+		path = expanded
+	}
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
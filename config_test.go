@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestIncludeMergesConfigurationFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "team"), 0755)
+	os.WriteFile(filepath.Join(dir, "team", "team.toml"), []byte(`
+[env]
+  MAVEN_OPTS = "-Xmx1g"
+  JAVA_HOME = "/opt/jdk21"
+
+[repositories]
+  default = ["/dev/shared", "/dev/app"]
+
+[commands]
+  status = "git status"
+
+[commands.dirty]
+  command = "git diff --quiet"
+  changed_codes = [1]
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "local.toml"), []byte(`
+[env]
+  JAVA_HOME = "/opt/jdk25"
+
+[repositories]
+  default = [{ path = "/dev/app", readonly = true }, "/dev/mine"]
+`), 0644)
+	file := filepath.Join(dir, "config.toml")
+	os.WriteFile(file, []byte(`
+include = ["team/team.toml", "./local.toml"]
+
+[commands]
+  dirty = "git diff --stat"
+`), 0644)
+
+	config := newConfiguration(file)
+
+	assertEqual(t, strings.Join(config.ListRepositories()["default"], ","), "/dev/shared,/dev/app,/dev/mine")
+	settings := config.Settings("/dev/app")
+	if !settings.Readonly {
+		t.Error("expected the local entry's attributes to apply")
+	}
+	assertEqual(t, settings.Env["JAVA_HOME"], "/opt/jdk25")
+	assertEqual(t, settings.Env["MAVEN_OPTS"], "-Xmx1g")
+	status, _ := config.Command("status")
+	assertEqual(t, status.Command, "git status")
+	dirty, _ := config.Command("dirty")
+	assertEqual(t, dirty.Command, "git diff --stat")
+	if len(dirty.ChangedCodes) != 0 {
+		t.Errorf("expected the command to be replaced as a whole, got changed codes %v", dirty.ChangedCodes)
+	}
+}
+
+func TestIncludeCanReplaceGroups(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "team.toml"), []byte(`
+[repositories]
+  default = ["/dev/shared"]
+  tools = ["/dev/tools"]
+`), 0644)
+	file := filepath.Join(dir, "config.toml")
+	os.WriteFile(file, []byte(`
+include = ["team.toml"]
+merge_groups = "replace"
+
+[repositories]
+  default = ["/dev/mine"]
+`), 0644)
+
+	repos := newConfiguration(file).ListRepositories()
+
+	assertEqual(t, strings.Join(repos["default"], ","), "/dev/mine")
+	assertEqual(t, strings.Join(repos["tools"], ","), "/dev/tools")
+}
+
+func TestIncludeCycleIsAnError(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.toml"), []byte(`include = ["b.toml"]`), 0644)
+	os.WriteFile(filepath.Join(dir, "b.toml"), []byte(`include = ["a.toml"]`), 0644)
+
+	if _, err := tryNewConfiguration(filepath.Join(dir, "a.toml")); err == nil {
+		t.Error("expected an error for an include cycle")
+	}
+}
+
+func TestWorkspaceConfigIsFoundInParentDirectories(t *testing.T) {
+	savedFlag := configFlag
+	defer func() { configFlag = savedFlag }()
+	configFlag = ""
+	t.Setenv("PARALLEL_GIT_REPO_CONFIG", "")
+
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	os.MkdirAll(filepath.Join(root, "services", "api", "src"), 0755)
+	file := filepath.Join(root, workspaceConfig)
+	os.WriteFile(file, []byte(`
+[repositories]
+  default = ["services/api", { path = "../shared" }, "/dev/abs"]
+`), 0644)
+	t.Chdir(filepath.Join(root, "services", "api", "src"))
+
+	assertEqual(t, configFile(), file)
+	repos := newConfiguration(configFile()).ListRepositories()
+	assertEqual(t, strings.Join(repos["default"], ","), filepath.Join(root, "services", "api")+","+filepath.Join(filepath.Dir(root), "shared")+",/dev/abs")
+}
+
+func TestRepositoryPathsExpandHomeAndVariables(t *testing.T) {
+	home, _ := homedir.Dir()
+	t.Setenv("WORKSPACE", "/src")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.toml")
+	os.WriteFile(file, []byte(`
+[repositories]
+  default = ["~/dev/app", "$HOME/dev/lib", { path = "${WORKSPACE}/api" }, "../other"]
+`), 0644)
+
+	repos := newConfiguration(file).ListRepositories()
+
+	assertEqual(t, strings.Join(repos["default"], ","), strings.Join([]string{
+		filepath.Join(home, "dev", "app"),
+		filepath.Join(os.Getenv("HOME"), "dev", "lib"),
+		"/src/api",
+		filepath.Join(filepath.Dir(dir), "other"),
+	}, ","))
+}
+
+func TestRepositoryPathsKeepUnsetVariables(t *testing.T) {
+	assertEqual(t, resolvePath("${PGR_UNSET_WORKSPACE}/tools", "/config"), "${PGR_UNSET_WORKSPACE}/tools")
+	assertEqual(t, resolvePath("$PGR_UNSET_WORKSPACE/tools", "/config"), "$PGR_UNSET_WORKSPACE/tools")
+}
+
+func TestEmptyConfigurationFilesAreEmptyInEveryFormat(t *testing.T) {
+	for _, name := range []string{"config.toml", "config.yaml", "config.json"} {
+		file := filepath.Join(t.TempDir(), name)
+		os.WriteFile(file, []byte("\n"), 0644)
+
+		config, err := tryNewConfiguration(file)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if repos := config.ListRepositories(); len(repos) != 0 {
+			t.Errorf("%s: got %v, want no repository", name, repos)
+		}
+	}
+}
+
+func TestYAMLAndJSONConfigurationsShareTheTOMLModel(t *testing.T) {
+	dir := t.TempDir()
+	documents := map[string]string{
+		"config.yaml": `
+repositories:
+  default:
+    - /dev/app
+    - path: /dev/release
+      readonly: true
+commands:
+  fetch: git fetch -p
+  dirty:
+    command: git diff --quiet
+    changed_codes: [1]
+`,
+		"config.json": `{
+  "repositories": {"default": ["/dev/app", {"path": "/dev/release", "readonly": true}]},
+  "commands": {"fetch": "git fetch -p", "dirty": {"command": "git diff --quiet", "changed_codes": [1]}}
+}`,
+	}
+	for name, document := range documents {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			os.WriteFile(file, []byte(document), 0644)
+
+			config := newConfiguration(file)
+
+			assertEqual(t, strings.Join(config.ListRepositories()["default"], ","), "/dev/app,/dev/release")
+			if !config.Settings("/dev/release").Readonly {
+				t.Error("expected /dev/release to be read-only")
+			}
+			fetch, _ := config.Command("fetch")
+			assertEqual(t, fetch.Command, "git fetch -p")
+			dirty, _ := config.Command("dirty")
+			if len(dirty.ChangedCodes) != 1 || dirty.ChangedCodes[0] != 1 {
+				t.Errorf("got changed codes %v, want [1]", dirty.ChangedCodes)
+			}
+		})
+	}
+}
+
+func TestConfigFileFallsBackToXDGConfigHome(t *testing.T) {
+	savedHome, savedFlag := home, configFlag
+	defer func() { home, configFlag = savedHome, savedFlag }()
+	t.Chdir(t.TempDir())
+	configFlag = ""
+	t.Setenv("PARALLEL_GIT_REPO_CONFIG", "")
+	home = t.TempDir()
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+
+	assertEqual(t, configFile(), filepath.Join(home, ".parallel-git-repositories"))
+
+	os.MkdirAll(filepath.Join(xdg, "parallel-git-repo"), 0755)
+	os.WriteFile(filepath.Join(xdg, "parallel-git-repo", "config.json"), []byte(`{}`), 0644)
+	assertEqual(t, configFile(), filepath.Join(xdg, "parallel-git-repo", "config.json"))
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	os.MkdirAll(filepath.Join(home, ".config", "parallel-git-repo"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "parallel-git-repo", "config.yaml"), []byte(""), 0644)
+	assertEqual(t, configFile(), filepath.Join(home, ".config", "parallel-git-repo", "config.yaml"))
+}
+
+func TestAddRepositoryWritesJSONConfiguration(t *testing.T) {
+	repo := gitRepository(t, "app", nil)
+	file := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(file, []byte(`{"repositories": {"default": ["/dev/lib"]}, "commands": {"fetch": "git fetch"}}`), 0644)
+
+	if err := addRepository(file, []string{repo}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := newConfiguration(file)
+	assertEqual(t, strings.Join(config.ListRepositories()["default"], ","), "/dev/lib,"+repo)
+	fetch, _ := config.Command("fetch")
+	assertEqual(t, fetch.Command, "git fetch")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// expand adds, after each repository, its linked worktrees when --worktrees is
+// set and the checked out submodules of each of them, recursively, when
+// --recurse-submodules is. The added paths share the settings of the
+// repository they were found in, so a read-only repository's worktrees are
+// read-only too.
+func (runner *runner) expand(repos []string) []string {
+	if !runner.worktrees && !runner.submodules {
+		return repos
+	}
+	found := make([][]string, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		paths := []string{repo}
+		if runner.worktrees {
+			paths = append(paths, runner.linkedWorktrees(ctx, repo)...)
+		}
+		for _, path := range paths {
+			found[i] = append(found[i], path)
+			if runner.submodules {
+				found[i] = append(found[i], runner.submodulesOf(ctx, path)...)
+			}
+		}
+	})
+
+	if runner.origins == nil {
+		runner.origins = make(map[string]string)
+	}
+	expanded := make([]string, 0, len(repos))
+	seen := make(map[string]bool)
+	for i, repo := range repos {
+		for _, path := range found[i] {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			expanded = append(expanded, path)
+			if path != repo {
+				runner.origins[path] = repo
+			}
+		}
+	}
+	return expanded
+}
+
+// linkedWorktrees returns the other worktrees of repo, leaving out bare ones
+// and those whose directory is gone.
+func (runner *runner) linkedWorktrees(ctx context.Context, repo string) []string {
+	out, err := runner.git(ctx, repo, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil
+	}
+	var worktrees []string
+	for _, block := range strings.Split(out, "\n\n") {
+		lines := strings.Split(block, "\n")
+		path, isWorktree := strings.CutPrefix(lines[0], "worktree ")
+		if !isWorktree || sameDirectory(path, repo) {
+			continue
+		}
+		usable := true
+		for _, line := range lines[1:] {
+			if line == "bare" || strings.HasPrefix(line, "prunable") {
+				usable = false
+			}
+		}
+		if usable {
+			worktrees = append(worktrees, path)
+		}
+	}
+	return worktrees
+}
+
+// submodulesOf returns the checked out submodules of repo, recursively.
+func (runner *runner) submodulesOf(ctx context.Context, repo string) []string {
+	out, err := runner.git(ctx, repo, "submodule", "--quiet", "foreach", "--recursive", `echo "$displaypath"`)
+	if err != nil || out == "" {
+		return nil
+	}
+	var submodules []string
+	for _, path := range strings.Split(out, "\n") {
+		submodules = append(submodules, filepath.Join(repo, path))
+	}
+	return submodules
+}
+
+// sameDirectory tells whether two paths lead to the same directory, git
+// reporting resolved paths where the configuration may go through symlinks.
+func sameDirectory(a, b string) bool {
+	if resolved, err := filepath.EvalSymlinks(a); err == nil {
+		a = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(b); err == nil {
+		b = resolved
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandIntoWorktreesAndSubmodules(t *testing.T) {
+	lib := gitRepository(t, "lib", nil)
+	app := gitRepository(t, "app", nil)
+	git(t, app, "-c", "protocol.file.allow=always", "submodule", "add", "-q", lib, "libs/lib")
+	git(t, app, "commit", "-q", "-m", "add lib")
+	feature := filepath.Join(t.TempDir(), "app-feature")
+	git(t, app, "worktree", "add", "-q", "-b", "feature", feature)
+
+	runner, output := testRunner(nil, settingsRepositories{
+		staticRepositories{"default": {app}},
+		map[string]settings{app: {Readonly: true}},
+	})
+	selected, _ := runner.selection("default")
+	assertEqual(t, strings.Join(selected, ","), app)
+
+	runner.worktrees = true
+	selected, _ = runner.selection("default")
+	assertEqual(t, strings.Join(selected, ","), app+","+feature)
+
+	runner.submodules = true
+	selected, _ = runner.selection("default")
+	assertEqual(t, strings.Join(selected, ","), app+","+filepath.Join(app, "libs/lib")+","+feature)
+	if !runner.settings(feature).Readonly {
+		t.Error("expected the worktree to share the settings of its repository")
+	}
+
+	runner.worktrees = false
+	runner.List("default")
+	assertEqual(t, output.String(), "default:\n  - "+app+"\n  - "+filepath.Join(app, "libs/lib")+"\n")
+}
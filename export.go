@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// workspace is the portable description of a set of repositories written by
+// `export`, enough to clone them elsewhere at the same state.
+type workspace struct {
+	Repositories []exportedRepository `json:"repositories" toml:"repositories"`
+}
+
+type exportedRepository struct {
+	Name string `json:"name" toml:"name"`
+	// Path is relative to the export root, with forward slashes.
+	Path string `json:"path" toml:"path"`
+	// Remotes maps remote names to their fetch URLs.
+	Remotes map[string]string `json:"remotes,omitempty" toml:"remotes,omitempty"`
+	// Branch is empty when HEAD is detached.
+	Branch string `json:"branch,omitempty" toml:"branch,omitempty"`
+	Sha    string `json:"sha" toml:"sha"`
+}
+
+// exportCommand implements the built-in `export`.
+func exportCommand(config *configuration, args []string, group string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "toml", "manifest format: toml or json")
+	root := fs.String("root", "", "directory the paths are relative to (default: the repositories' common parent)")
+	output := fs.String("o", "", "write the manifest to this file instead of the standard output")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *format != "toml" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown export format %q, expected toml or json\n", *format)
+		return 1
+	}
+
+	runner := configuredRunner(nil, config)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		runner.writer = file
+	}
+	return runner.Export(group, *root, *format)
+}
+
+// Export writes the remotes, current branch and HEAD of the selected
+// repositories as a workspace manifest, their paths relative to root.
+func (runner *runner) Export(group, root, format string) int {
+	repos, err := runner.selection(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if root == "" {
+		root = commonParent(repos)
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	exported := make([]exportedRepository, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		exported[i], errs[i] = runner.describe(ctx, repo, root)
+	})
+
+	failures := 0
+	manifest := workspace{Repositories: make([]exportedRepository, 0, len(repos))}
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(os.Stderr, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		manifest.Repositories = append(manifest.Repositories, exported[i])
+	}
+	if err := writeManifest(runner.writer, manifest, format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return failures
+}
+
+func (runner *runner) describe(ctx context.Context, repo, root string) (exportedRepository, error) {
+	path, err := filepath.Rel(root, repo)
+	if err != nil {
+		return exportedRepository{}, err
+	}
+	state := exportedRepository{Name: filepath.Base(repo), Path: filepath.ToSlash(path)}
+	if state.Sha, err = runner.git(ctx, repo, "rev-parse", "--verify", "HEAD"); err != nil {
+		return state, err
+	}
+	state.Branch, _ = runner.git(ctx, repo, "symbolic-ref", "--short", "--quiet", "HEAD")
+	// Exits with 1 when no remote is configured.
+	urls, _ := runner.git(ctx, repo, "config", "--get-regexp", `^remote\..*\.url$`)
+	for _, line := range strings.Split(urls, "\n") {
+		if key, url, found := strings.Cut(line, " "); found {
+			if state.Remotes == nil {
+				state.Remotes = make(map[string]string)
+			}
+			state.Remotes[strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")] = url
+		}
+	}
+	return state, nil
+}
+
+func writeManifest(w io.Writer, manifest workspace, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(manifest)
+	}
+	return toml.NewEncoder(w).Order(toml.OrderPreserve).Encode(manifest)
+}
+
+// commonParent returns the deepest directory holding every repository.
+func commonParent(repos []string) string {
+	if len(repos) == 0 {
+		return "."
+	}
+	parent := filepath.Dir(repos[0])
+	for _, repo := range repos[1:] {
+		for parent != filepath.Dir(parent) && !strings.HasPrefix(repo, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator)) {
+			parent = filepath.Dir(parent)
+		}
+	}
+	return parent
+}
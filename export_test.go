@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestExportDescribesEveryRepository(t *testing.T) {
+	origin := gitRepository(t, "origin", nil)
+	root := t.TempDir()
+	app := filepath.Join(root, "services", "app")
+	git(t, origin, "clone", "-q", origin, app)
+	git(t, app, "switch", "-q", "-c", "feature")
+	lib := gitRepository(t, "lib", nil)
+	git(t, lib, "switch", "-q", "--detach")
+
+	runner, output := testRunner(nil, staticRepositories{"default": {app}})
+
+	if failures := runner.Export("default", root, "json"); failures != 0 {
+		t.Fatalf("got %d failures, want 0", failures)
+	}
+	var manifest workspace
+	if err := json.Unmarshal(output.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Repositories) != 1 {
+		t.Fatalf("got %d repositories, want 1", len(manifest.Repositories))
+	}
+	exported := manifest.Repositories[0]
+	assertEqual(t, exported.Name, "app")
+	assertEqual(t, exported.Path, "services/app")
+	assertEqual(t, exported.Remotes["origin"], origin)
+	assertEqual(t, exported.Branch, "feature")
+	assertEqual(t, exported.Sha, git(t, origin, "rev-parse", "HEAD"))
+
+	output.Reset()
+	runner.repos = staticRepositories{"default": {app, lib}}
+	if failures := runner.Export("default", "", "toml"); failures != 0 {
+		t.Fatalf("got %d failures, want 0", failures)
+	}
+	tree, err := toml.LoadBytes(output.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositories := tree.Get("repositories").([]*toml.Tree)
+	assertEqual(t, repositories[0].Get("path").(string), filepath.ToSlash(mustRel(t, commonParent([]string{app, lib}), app)))
+	assertEqual(t, repositories[1].Get("name").(string), "lib")
+	if repositories[1].Has("branch") {
+		t.Error("expected no branch for a detached HEAD")
+	}
+}
+
+func TestCommonParent(t *testing.T) {
+	assertEqual(t, commonParent([]string{"/dev/app"}), "/dev")
+	assertEqual(t, commonParent([]string{"/dev/services/app", "/dev/services/api", "/dev/lib"}), "/dev")
+	assertEqual(t, commonParent([]string{"/dev/app", "/devtools/lib"}), "/")
+}
+
+func mustRel(t *testing.T, base, target string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
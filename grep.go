@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jcgay/parallel-git-repo/parallel"
+)
+
+// grepMatch is one line reported by `git grep`, tagged with its repository.
+type grepMatch struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	Text       string `json:"text"`
+}
+
+// grepResult sums up `git grep` in one repository.
+type grepResult struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+	Matches    int    `json:"matches"`
+	Error      string `json:"error,omitempty"`
+	matches    []grepMatch
+}
+
+// grepOptionsWithValue are the git grep options taking the next argument as
+// their value, which may well be --json when searching for it.
+var grepOptionsWithValue = []string{"-e", "-f", "-A", "-B", "-C", "-m", "--after-context", "--before-context", "--context", "--max-count", "--max-depth", "--threads"}
+
+// grepCommand implements the built-in `grep`. Going through `run git grep`
+// indents each repository's matches under a ✔ and reports repositories
+// without any match as ✘, because git grep exits 1 when nothing matched.
+func grepCommand(config *configuration, args []string, group string) int {
+	grepArgs, asJSON := grepArguments(args)
+	runner := configuredRunner(&run{ToExec: []string{"git", "grep", "-n", "--null", "--no-color", "$@"}}, config)
+	return runner.Grep(grepArgs, group, asJSON)
+}
+
+// grepArguments separates our --json from the arguments of git grep. They
+// cannot go through a FlagSet that would reject -i, -w, -e and friends.
+func grepArguments(args []string) (grepArgs []string, asJSON bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			grepArgs = append(grepArgs, args[i:]...)
+			break
+		}
+		if arg == "--json" || arg == "-json" {
+			asJSON = true
+			continue
+		}
+		grepArgs = append(grepArgs, arg)
+		if slices.Contains(grepOptionsWithValue, arg) && i+1 < len(args) {
+			// The value, such as the pattern of -e, is passed as is.
+			i++
+			grepArgs = append(grepArgs, args[i])
+		}
+	}
+	return grepArgs, asJSON
+}
+
+// Grep runs the command, expected to be a `git grep -n --null`, in every
+// repository and merges the matches into a single listing prefixed with
+// repo/path:line:, followed by the number of matches per repository. A
+// repository without any match counts zero matches, not a failure.
+func (runner *runner) Grep(args []string, group string, asJSON bool) int {
+	repos, err := runner.selection(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	argv := parallel.ForwardArgs(runner.runnableCommand.Options(), args)
+
+	results := make([]grepResult, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		command := runner.command(ctx, repo, argv)
+		var stdout, stderr bytes.Buffer
+		command.Stdout = &stdout
+		command.Stderr = &stderr
+
+		err := runner.execute(ctx, command)
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 1 && stderr.Len() == 0 {
+			err = nil
+		}
+
+		result := grepResult{Repository: filepath.Base(repo), Path: repo}
+		if err != nil {
+			result.Error = strings.TrimSpace(fmt.Sprintf("%v\n%s", err, stderr.String()))
+		}
+		for _, line := range strings.Split(stdout.String(), "\n") {
+			if line != "" {
+				result.matches = append(result.matches, parseGrepLine(result.Repository, line))
+			}
+		}
+		result.Matches = len(result.matches)
+		results[i] = result
+	})
+
+	failures := 0
+	matches := make([]grepMatch, 0)
+	for _, result := range results {
+		if result.Error != "" {
+			failures++
+		}
+		matches = append(matches, result.matches...)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(runner.writer)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(struct {
+			Matches      []grepMatch  `json:"matches"`
+			Repositories []grepResult `json:"repositories"`
+		}{matches, results})
+		return failures
+	}
+
+	for _, match := range matches {
+		fmt.Fprintf(runner.writer, "%s/%s:%d:%s\n", match.Repository, match.Path, match.Line, match.Text)
+	}
+	if len(matches) > 0 {
+		fmt.Fprintln(runner.writer)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(runner.writer, "%s: %s\n  %s\n", result.Repository, ko, result.Error)
+			continue
+		}
+		fmt.Fprintf(runner.writer, "%s: %d %s\n", result.Repository, result.Matches, plural(result.Matches, "match", "matches"))
+	}
+	return failures
+}
+
+// parseGrepLine splits a `git grep -n --null` line: path, line number and text
+// are NUL separated, so paths and text may safely contain colons.
+func parseGrepLine(repository, line string) grepMatch {
+	match := grepMatch{Repository: repository}
+	fields := strings.SplitN(line, "\x00", 3)
+	match.Path = fields[0]
+	switch len(fields) {
+	case 3:
+		match.Line, _ = strconv.Atoi(fields[1])
+		match.Text = fields[2]
+	case 2:
+		match.Text = fields[1]
+	}
+	return match
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
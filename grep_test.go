@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// grepExec is the command line grepCommand runs.
+var grepExec = []string{"git", "grep", "-n", "--null", "--no-color", "$@"}
+
+func TestGrepMergesMatchesAcrossRepositories(t *testing.T) {
+	color := gitRepository(t, "maven-color", map[string]string{"pom.xml": "<version>1.0</version>\n", "src/a:b.txt": "see version: 2\n"})
+	notifier := gitRepository(t, "maven-notifier", map[string]string{"README": "nothing here\n"})
+	runner, output := testRunner(&run{ToExec: grepExec}, staticRepositories{"default": {color, notifier}})
+
+	// No match in maven-notifier is a zero count, not a failure.
+	if failures := runner.Grep([]string{"version"}, "default", false); failures != 0 {
+		t.Errorf("got %d failures, want 0", failures)
+	}
+
+	want := "maven-color/pom.xml:1:<version>1.0</version>\n" +
+		"maven-color/src/a:b.txt:1:see version: 2\n" +
+		"\n" +
+		"maven-color: 2 matches\n" +
+		"maven-notifier: 0 matches\n"
+	assertEqual(t, output.String(), want)
+}
+
+func TestGrepReportsJSON(t *testing.T) {
+	repo := gitRepository(t, "maven-color", map[string]string{"pom.xml": "<version>1.0</version>\n"})
+	runner, output := testRunner(&run{ToExec: grepExec}, staticRepositories{"default": {repo}})
+
+	runner.Grep([]string{"-i", "VERSION"}, "default", true)
+
+	var got struct {
+		Matches      []grepMatch
+		Repositories []grepResult
+	}
+	if err := json.Unmarshal(output.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", output.String(), err)
+	}
+	if len(got.Matches) != 1 || got.Matches[0].Path != "pom.xml" || got.Matches[0].Line != 1 {
+		t.Errorf("unexpected matches %+v", got.Matches)
+	}
+	if len(got.Repositories) != 1 || got.Repositories[0].Matches != 1 {
+		t.Errorf("unexpected repositories %+v", got.Repositories)
+	}
+}
+
+func TestGrepCountsRealErrorsAsFailures(t *testing.T) {
+	runner, output := testRunner(&run{ToExec: grepExec}, staticRepositories{"default": {t.TempDir()}})
+
+	if failures := runner.Grep([]string{"version"}, "default", false); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+	if !strings.Contains(output.String(), ko) {
+		t.Errorf("expected a failure marker, got %q", output.String())
+	}
+}
+
+func TestGrepJSONFlagIsNotTakenFromOptionValues(t *testing.T) {
+	args, asJSON := grepArguments([]string{"-e", "--json", "-i", "--json"})
+	assertEqual(t, strings.Join(args, " "), "-e --json -i")
+	if !asJSON {
+		t.Error("expected the trailing --json to select JSON output")
+	}
+
+	args, asJSON = grepArguments([]string{"-e", "--json", "--", "--json"})
+	assertEqual(t, strings.Join(args, " "), "-e --json -- --json")
+	if asJSON {
+		t.Error("expected --json as a pattern or after -- to be searched for")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const importUsage = `usage: parallel-git-repo import [-g GROUP] [-format manifest|gitmodules|mrconfig|list] [-root DIR] [FILE|-]`
+
+// importers read the repository paths listed by the tools people migrate
+// from, relative paths left as written.
+var importers = map[string]func([]byte) ([]string, error){
+	"manifest":   manifestPaths,
+	"gitmodules": gitmodulesPaths,
+	"mrconfig":   mrconfigPaths,
+	"list":       listPaths,
+}
+
+// importRepositories adds every repository listed in a repo tool manifest,
+// a .gitmodules, a myrepos .mrconfig or a plain list of paths (read from the
+// standard input by default) to a group, through the same path as `add`.
+func importRepositories(file string, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	group := fs.String("g", "default", "group to add the repositories to")
+	format := fs.String("format", "", "format of the source, guessed from its name when empty: manifest, gitmodules, mrconfig or list")
+	root := fs.String("root", "", "directory the listed paths are relative to (default: the checkout holding the source)")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return errors.New(importUsage)
+	}
+	source := "-"
+	if len(positional) == 1 {
+		source = positional[0]
+	}
+	if *format == "" {
+		*format = importFormat(source)
+	}
+	parse, found := importers[*format]
+	if !found {
+		return fmt.Errorf("Unknown import format %q\n%s", *format, importUsage)
+	}
+
+	var content []byte
+	if source == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return err
+	}
+	listed, err := parse(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+
+	dir := *root
+	if dir == "" {
+		dir = importRoot(source, *format)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	paths := make([]string, len(listed))
+	for i, path := range listed {
+		paths[i] = resolvePath(path, dir)
+	}
+
+	added, err := addToGroup(file, *group, paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range added {
+		fmt.Printf("Added %s to group %q\n", path, *group)
+	}
+	if skipped := len(paths) - len(added); skipped > 0 {
+		fmt.Printf("%d %s already in group %q\n", skipped, plural(skipped, "repository", "repositories"), *group)
+	}
+	return nil
+}
+
+// importFormat guesses the format of source from its name.
+func importFormat(source string) string {
+	switch name := filepath.Base(source); {
+	case source == "-":
+		return "list"
+	case name == ".gitmodules":
+		return "gitmodules"
+	case name == ".mrconfig":
+		return "mrconfig"
+	case strings.EqualFold(filepath.Ext(name), ".xml"):
+		return "manifest"
+	}
+	return "list"
+}
+
+// importRoot returns the directory the paths listed in source are relative
+// to: the working directory for a list, the checkout holding the .repo
+// directory for a repo manifest, else the directory of source.
+func importRoot(source, format string) string {
+	if format == "list" {
+		return "."
+	}
+	dir := filepath.Dir(source)
+	if format == "manifest" {
+		abs, _ := filepath.Abs(dir)
+		for parent := abs; parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+			if filepath.Base(parent) == ".repo" {
+				return filepath.Dir(parent)
+			}
+		}
+	}
+	return dir
+}
+
+// manifestPaths reads the projects of a repo tool manifest, checked out at
+// their path, or their name when it is unset.
+func manifestPaths(content []byte) ([]string, error) {
+	var manifest struct {
+		Projects []struct {
+			Name string `xml:"name,attr"`
+			Path string `xml:"path,attr"`
+		} `xml:"project"`
+	}
+	if err := xml.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(manifest.Projects))
+	for _, project := range manifest.Projects {
+		if project.Path != "" {
+			paths = append(paths, project.Path)
+		} else {
+			paths = append(paths, project.Name)
+		}
+	}
+	return paths, nil
+}
+
+// gitmodulesPaths reads the submodule paths of a .gitmodules, parsed by git
+// itself since it uses the git config syntax.
+func gitmodulesPaths(content []byte) ([]string, error) {
+	cmd := exec.Command("git", "config", "--file", "-", "--get-regexp", `^submodule\..*\.path$`)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 && stderr.Len() == 0 {
+		// No submodule.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if _, path, found := strings.Cut(line, " "); found {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// mrconfigPaths reads the repositories of a myrepos .mrconfig: one section per
+// repository, named after its path.
+func mrconfigPaths(content []byte) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if section := strings.TrimSpace(line[1 : len(line)-1]); section != "DEFAULT" && section != "" {
+				paths = append(paths, section)
+			}
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// listPaths reads one path per line, skipping blank lines and # comments.
+func listPaths(content []byte) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
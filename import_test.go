@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportRepoManifest(t *testing.T) {
+	checkout, _ := filepath.EvalSymlinks(t.TempDir())
+	manifest := filepath.Join(checkout, ".repo", "manifests", "default.xml")
+	os.MkdirAll(filepath.Dir(manifest), 0755)
+	os.WriteFile(manifest, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest>
+  <remote name="aosp" fetch="https://android.googlesource.com"/>
+  <default revision="main" remote="aosp"/>
+  <project path="build/make" name="platform/build"/>
+  <project name="tools/repohooks"/>
+</manifest>`), 0644)
+	file := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := importRepositories(file, []string{manifest, "-g", "android"}); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newConfiguration(file).ListRepositories()
+	assertEqual(t, strings.Join(repos["android"], ","), filepath.Join(checkout, "build/make")+","+filepath.Join(checkout, "tools/repohooks"))
+}
+
+func TestImportGitmodulesAndMrconfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".gitmodules"), []byte(`
+[submodule "jansi"]
+	path = libs/jansi
+	url = https://github.com/fusesource/jansi.git
+[submodule "color"]
+	path = libs/color
+	url = https://github.com/jcgay/maven-color.git
+`), 0644)
+	os.WriteFile(filepath.Join(dir, ".mrconfig"), []byte(`
+[DEFAULT]
+lib = echo
+
+[libs/jansi]
+checkout = git clone https://github.com/fusesource/jansi.git jansi
+
+[/dev/notifier]
+checkout = git clone https://github.com/jcgay/maven-notifier.git notifier
+`), 0644)
+	file := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := importRepositories(file, []string{filepath.Join(dir, ".gitmodules")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importRepositories(file, []string{filepath.Join(dir, ".mrconfig")}); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newConfiguration(file).ListRepositories()
+	assertEqual(t, strings.Join(repos["default"], ","), strings.Join([]string{
+		filepath.Join(dir, "libs/jansi"),
+		filepath.Join(dir, "libs/color"),
+		"/dev/notifier",
+	}, ","))
+}
+
+func TestImportPathListFromStandardInput(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "paths")
+	os.WriteFile(input, []byte("# team repositories\n/dev/app\n\n/dev/lib\n"), 0644)
+	stdin, _ := os.Open(input)
+	defer stdin.Close()
+	saved := os.Stdin
+	defer func() { os.Stdin = saved }()
+	os.Stdin = stdin
+	file := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(file, []byte("[repositories]\n  default = [\"/dev/app\"]\n"), 0644)
+
+	if err := importRepositories(file, []string{"-"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, strings.Join(newConfiguration(file).ListRepositories()["default"], ","), "/dev/app,/dev/lib")
+}
+
+func TestImportFormatIsGuessedFromTheSourceName(t *testing.T) {
+	assertEqual(t, importFormat("-"), "list")
+	assertEqual(t, importFormat("/src/.gitmodules"), "gitmodules")
+	assertEqual(t, importFormat("/home/me/.mrconfig"), "mrconfig")
+	assertEqual(t, importFormat(".repo/manifests/default.xml"), "manifest")
+	assertEqual(t, importFormat("repos.txt"), "list")
+}
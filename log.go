@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcgay/parallel-git-repo/parallel"
+)
+
+// logFormat separates the fields of a commit with US (0x1f) and commits with RS
+// (0x1e), bytes that cannot appear in a subject line.
+const logFormat = "--format=%H%x1f%aI%x1f%an%x1f%s%x1e"
+
+// logEntry is one commit of the merged timeline.
+type logEntry struct {
+	Repository string    `json:"repository"`
+	Commit     string    `json:"commit"`
+	Date       time.Time `json:"date"`
+	Author     string    `json:"author"`
+	Subject    string    `json:"subject"`
+}
+
+// logCommand implements the built-in `log`: `git log` in every repository,
+// interleaved into one date-sorted timeline instead of one block per
+// repository. --since, --author and --grep are passed through to git log, as
+// is any remaining argument (e.g. a revision range).
+func logCommand(config *configuration, args []string, group string) int {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	since := fs.String("since", "", "only show commits more recent than this date, e.g. \"last monday\"")
+	author := fs.String("author", "", "only show commits whose author matches this pattern")
+	grep := fs.String("grep", "", "only show commits whose message matches this pattern")
+	asJSON := fs.Bool("json", false, "print the timeline as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	toExec := []string{"git", "log", "--no-color", logFormat}
+	if *since != "" {
+		toExec = append(toExec, "--since="+*since)
+	}
+	if *author != "" {
+		toExec = append(toExec, "--author="+*author)
+	}
+	if *grep != "" {
+		toExec = append(toExec, "--grep="+*grep)
+	}
+	toExec = append(toExec, "$@")
+
+	runner := configuredRunner(&run{ToExec: toExec}, config)
+	return runner.Log(fs.Args(), group, *asJSON)
+}
+
+// Log runs the command, expected to be a `git log` printing logFormat, in every
+// repository and prints the commits of all of them newest first, tagged with
+// their repository name.
+func (runner *runner) Log(args []string, group string, asJSON bool) int {
+	repos, err := runner.selection(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	argv := parallel.ForwardArgs(runner.runnableCommand.Options(), args)
+
+	entries := make([][]logEntry, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		command := runner.command(ctx, repo, argv)
+		var stdout, stderr bytes.Buffer
+		command.Stdout = &stdout
+		command.Stderr = &stderr
+
+		if err := runner.execute(ctx, command); err != nil {
+			errs[i] = fmt.Errorf("%v\n  %s", err, strings.TrimSpace(stderr.String()))
+			return
+		}
+		entries[i], errs[i] = parseLog(filepath.Base(repo), stdout.String())
+	})
+
+	failures := 0
+	timeline := make([]logEntry, 0)
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(os.Stderr, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		timeline = append(timeline, entries[i]...)
+	}
+	// Stable, so commits sharing a timestamp keep their repository order.
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Date.After(timeline[j].Date)
+	})
+
+	if asJSON {
+		encoder := json.NewEncoder(runner.writer)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(timeline)
+		return failures
+	}
+
+	width := 0
+	for _, entry := range timeline {
+		if n := len(entry.Repository); n > width {
+			width = n
+		}
+	}
+	for _, entry := range timeline {
+		fmt.Fprintf(runner.writer, "%s  %-*s  %.7s  %s: %s\n", entry.Date.Local().Format("2006-01-02 15:04"), width, entry.Repository, entry.Commit, entry.Author, entry.Subject)
+	}
+	return failures
+}
+
+func parseLog(repository, output string) ([]logEntry, error) {
+	var entries []logEntry
+	for _, record := range strings.Split(output, "\x1e") {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\x1f", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git log output %q", record)
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, logEntry{
+			Repository: repository,
+			Commit:     fields[0],
+			Date:       date,
+			Author:     fields[2],
+			Subject:    fields[3],
+		})
+	}
+	return entries, nil
+}
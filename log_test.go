@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// logExec is the command line logCommand runs without filters.
+var logExec = []string{"git", "log", "--no-color", logFormat, "$@"}
+
+// commitAt records an empty commit authored and committed at date.
+func commitAt(t *testing.T, dir, subject, date string) {
+	t.Helper()
+	t.Setenv("GIT_COMMITTER_DATE", date)
+	git(t, dir, "commit", "-q", "--allow-empty", "-m", subject, "--date", date)
+}
+
+func TestLogInterleavesRepositoriesByDate(t *testing.T) {
+	color := gitRepository(t, "maven-color", nil)
+	notifier := gitRepository(t, "maven-notifier", nil)
+	commitAt(t, color, "color: first", "2026-01-01T10:00:00Z")
+	commitAt(t, notifier, "notifier: second", "2026-01-02T10:00:00Z")
+	commitAt(t, color, "color: third", "2026-01-03T10:00:00Z")
+	runner, output := testRunner(&run{ToExec: logExec}, staticRepositories{"default": {color, notifier}})
+
+	// Leave the initial commits, dated now, out of the timeline.
+	if failures := runner.Log([]string{"--until=2026-02-01"}, "default", false); failures != 0 {
+		t.Fatalf("got %d failures, want 0", failures)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 commits, got %q", output.String())
+	}
+	for i, want := range []string{"maven-color     ", "maven-notifier  ", "maven-color     "} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d: got %q, want it tagged %q", i, lines[i], want)
+		}
+	}
+	for i, want := range []string{"Test: color: third", "Test: notifier: second", "Test: color: first"} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("line %d: got %q, want it to end with %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestLogPrintsJSON(t *testing.T) {
+	repo := gitRepository(t, "maven-color", nil)
+	commitAt(t, repo, "fix: colours", "2026-01-01T10:00:00Z")
+	runner, output := testRunner(&run{ToExec: logExec}, staticRepositories{"default": {repo}})
+
+	runner.Log([]string{"--grep=colours"}, "default", true)
+
+	var entries []logEntry
+	if err := json.Unmarshal(output.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid JSON %q: %v", output.String(), err)
+	}
+	if len(entries) != 1 || entries[0].Subject != "fix: colours" || entries[0].Repository != "maven-color" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
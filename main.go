@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -12,6 +14,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,6 +23,7 @@ import (
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/jcgay/parallel-git-repo/parallel"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pelletier/go-toml"
 )
@@ -61,12 +65,75 @@ var (
 	stream       bool
 	configFlag   string
 	failed       bool
+	colorMode    string
+	interactive  bool
+	feedStdin    bool
+	assumeYes    bool
+	labels       string
+	excludes     repeated
+	only         repositoryPatterns
+	worktrees    bool
+	submodules   bool
 )
 
+// repeated collects the values of a flag given several times.
+type repeated []string
+
+func (r *repeated) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *repeated) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// repositoryPatterns collects -r values: a repository directory name or path,
+// or a regular expression between slashes matched against both.
+type repositoryPatterns []string
+
+func (p *repositoryPatterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *repositoryPatterns) Set(value string) error {
+	if expr, ok := regexpPattern(value); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return err
+		}
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+func regexpPattern(pattern string) (string, bool) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1], true
+	}
+	return "", false
+}
+
+// matchRepository reports whether repo is the one designated by pattern, by
+// directory name or path, or matches it when pattern is a /regex/.
+func matchRepository(pattern, repo string) bool {
+	if expr, ok := regexpPattern(pattern); ok {
+		re, err := regexp.Compile(expr)
+		return err == nil && (re.MatchString(filepath.Base(repo)) || re.MatchString(repo))
+	}
+	if pattern == filepath.Base(repo) {
+		return true
+	}
+	abs, err := filepath.Abs(pattern)
+	return err == nil && abs == filepath.Clean(repo)
+}
+
 // configFile resolves the configuration file path: the -c flag wins, then the
-// PARALLEL_GIT_REPO_CONFIG environment variable, then the historical
-// $HOME/.parallel-git-repositories. A configurable path lets a team version its
-// config inside a repository and keeps separate work/personal setups apart.
+// PARALLEL_GIT_REPO_CONFIG environment variable, then a workspace file found in
+// the current directory or one of its parents, then an existing
+// $XDG_CONFIG_HOME/parallel-git-repo/config.{toml,yaml,json}, then the
+// historical $HOME/.parallel-git-repositories. A configurable path lets a team
+// version its config inside a repository and keeps separate work/personal
+// setups apart.
 func configFile() string {
 	if configFlag != "" {
 		return configFlag
@@ -74,9 +141,53 @@ func configFile() string {
 	if env := os.Getenv("PARALLEL_GIT_REPO_CONFIG"); env != "" {
 		return env
 	}
+	if workspace := workspaceConfigFile(); workspace != "" {
+		return workspace
+	}
+	if xdg := xdgConfigFile(); xdg != "" {
+		return xdg
+	}
 	return home + "/.parallel-git-repositories"
 }
 
+// xdgConfigFile returns the first existing config file under
+// $XDG_CONFIG_HOME/parallel-git-repo, ~/.config when the variable is unset.
+func xdgConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".config")
+	}
+	for _, name := range []string{"config.toml", "config.yaml", "config.json"} {
+		file := filepath.Join(dir, "parallel-git-repo", name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// workspaceConfig is the name of a configuration committed at the root of a
+// checkout tree, picked up from any directory below it.
+const workspaceConfig = ".parallel-git-repo.toml"
+
+func workspaceConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		file := filepath.Join(dir, workspaceConfig)
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
 const help = `NAME:
   Parallel Git Repositories - Execute commands on multiple Git repositories in parallel!
 
@@ -92,8 +203,34 @@ GLOBAL OPTIONS:
   -h	show help
 `
 
-var ok = color.New(color.FgGreen).SprintFunc()("✔")
-var ko = color.New(color.FgRed).SprintFunc()("✘")
+// ok, ko and changedMark mark each repository's result: coloured symbols on a
+// terminal, plain OK/FAIL/CHANGED text when colour is disabled so CI logs and
+// redirected output are not littered with ANSI escape codes around them.
+var ok, ko, changedMark = markers()
+
+func markers() (string, string, string) {
+	if color.NoColor {
+		return "OK", "FAIL", "CHANGED"
+	}
+	return color.New(color.FgGreen).Sprint("✔"), color.New(color.FgRed).Sprint("✘"), color.New(color.FgYellow).Sprint("●")
+}
+
+// setColor applies the --color mode. "auto" keeps fatih/color's own detection,
+// which already disables colour for NO_COLOR, TERM=dumb and a stdout that is
+// not a terminal; "always" and "never" override it.
+func setColor(mode string) error {
+	switch mode {
+	case "auto":
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		return fmt.Errorf("Invalid color mode %q, expected auto, always or never", mode)
+	}
+	ok, ko, changedMark = markers()
+	return nil
+}
 
 func main() {
 	if home == "" {
@@ -109,11 +246,22 @@ func main() {
 	flag.IntVar(&jobs, "j", 8, "maximum number of commands run in parallel")
 	flag.DurationVar(&timeout, "timeout", 60*time.Second, "kill a command that runs longer than this (0 disables)")
 	flag.BoolVar(&stream, "stream", false, "stream each repository's output live, prefixed with its name, instead of buffering whole blocks")
-	flag.StringVar(&configFlag, "c", "", "path to the configuration file (defaults to $PARALLEL_GIT_REPO_CONFIG, then $HOME/.parallel-git-repositories)")
+	flag.StringVar(&configFlag, "c", "", "path to the configuration file (defaults to $PARALLEL_GIT_REPO_CONFIG, then .parallel-git-repo.toml in this or a parent directory, then $XDG_CONFIG_HOME/parallel-git-repo/config.{toml,yaml,json}, then $HOME/.parallel-git-repositories)")
 	flag.BoolVar(&failed, "failed", false, "only print repositories whose command failed, followed by a ✔/✘ summary line")
+	flag.BoolVar(&interactive, "i", false, "run repositories one at a time attached to the terminal, for commands that prompt (shorthand for -interactive)")
+	flag.BoolVar(&interactive, "interactive", false, "run repositories one at a time attached to the terminal, for commands that prompt")
+	flag.BoolVar(&feedStdin, "stdin", false, "read stdin once and feed it to the command in every repository")
+	flag.BoolVar(&assumeYes, "yes", false, "do not ask for confirmation before destructive commands")
+	flag.StringVar(&colorMode, "color", "auto", "colorize output: auto, always or never (auto honours NO_COLOR and disables colour when stdout is not a terminal)")
 
 	var group string
 	flag.StringVar(&group, "g", "default", "execute command for a specific repositories group")
+	flag.StringVar(&labels, "l", "", "only select repositories whose labels match, e.g. java,!legacy (shorthand for -label)")
+	flag.Var(&only, "r", "only select the repository with this directory name or path, or matching this /regex/ (repeatable)")
+	flag.Var(&excludes, "exclude", "leave out the repository with this path or directory name (repeatable)")
+	flag.BoolVar(&worktrees, "worktrees", false, "also select every linked worktree of the selected repositories")
+	flag.BoolVar(&submodules, "recurse-submodules", false, "also select the checked out submodules of the selected repositories, recursively")
+	flag.StringVar(&labels, "label", "", "only select repositories whose labels match: terms separated by , must all hold, | separates alternatives, ! negates")
 
 	ver, commit := buildInfo()
 
@@ -124,6 +272,10 @@ func main() {
 
 	flag.Parse()
 
+	if err := setColor(colorMode); err != nil {
+		log.Fatal(err)
+	}
+
 	if printVersion {
 		fmt.Printf("version: %s (%s)", ver, commit)
 		return
@@ -138,29 +290,64 @@ func main() {
 		os.Exit(1)
 	}
 
-	if args[0] == "add" {
+	// Parsed leniently first: add and import must work without a valid config.
+	config, _ := tryNewConfiguration(configFile())
+	if args[0] == "add" || (args[0] == "import" && !shadowed(config, args[0])) {
 		// Handled before newConfiguration so a missing or hand-broken config
-		// file doesn't block the very command meant to write it.
-		if err := addRepository(configFile(), args[1:]); err != nil {
+		// file doesn't block the very commands meant to write it.
+		write := addRepository
+		if args[0] == "import" {
+			write = importRepositories
+		}
+		if err := write(configFile(), args[1:]); err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
 	configuration := newConfiguration(configFile())
-	if args[0] == "list" {
-		repos, err := filterGroup(configuration.ListRepositories(), group)
-		if err != nil {
+	builtin := args[0]
+	if shadowed(configuration, builtin) {
+		// Run by runCommand in the default case.
+		builtin = ""
+	}
+	switch builtin {
+	case "list":
+		if err := configuredRunner(nil, configuration).List(group); err != nil {
 			log.Fatal(err)
 		}
-		for _, key := range sortedKeys(repos) {
-			fmt.Printf("%s:\n", key)
-			for _, repo := range repos[key] {
-				fmt.Printf("  - %s\n", repo)
-			}
+	case "grep":
+		if grepCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	case "log":
+		if logCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	case "branch":
+		if branchCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	case "sync":
+		if syncCommand(configuration, group) > 0 {
+			os.Exit(1)
+		}
+	case "snapshot":
+		if snapshotCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	case "export":
+		if exportCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	case "prune-branches":
+		if pruneCommand(configuration, args[1:], group) > 0 {
+			os.Exit(1)
+		}
+	default:
+		if runCommand(configuration, args, group) > 0 {
+			os.Exit(1)
 		}
-	} else if runCommand(configuration, args, group) > 0 {
-		os.Exit(1)
 	}
 }
 
@@ -190,46 +377,181 @@ func addRepository(file string, args []string) error {
 		return fmt.Errorf("%s is not a Git repository", path)
 	}
 
-	tree, err := toml.LoadFile(file)
+	added, err := addToGroup(file, *group, []string{path})
+	if err != nil {
+		return err
+	}
+	if len(added) == 0 {
+		return fmt.Errorf("%s is already in group %q", path, *group)
+	}
+	fmt.Printf("Added %s to group %q\n", path, *group)
+	return nil
+}
+
+// addToGroup appends the absolute paths to group in the config file, created
+// along with the group if missing, and returns those it added: paths already
+// in the group are skipped. Appending to the raw entries keeps the attributes
+// of table entries and the rest of the file.
+func addToGroup(file, group string, paths []string) ([]string, error) {
+	tree, err := loadTree(file)
 	if os.IsNotExist(err) {
 		tree, err = toml.Load("")
 	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return nil, err
 	}
 
-	members := (&configuration{tree}).ListRepositories()[*group]
-	for _, repo := range members {
-		if repo == path {
-			return fmt.Errorf("%s is already in group %q", path, *group)
+	var added []string
+	entries := repositoryEntries(tree.GetPath([]string{"repositories", group}))
+	for _, path := range paths {
+		if slices.ContainsFunc(entries, func(entry interface{}) bool { return resolvePath(repositoryPath(entry), dir) == path }) {
+			continue
 		}
+		entries = append(entries, path)
+		added = append(added, path)
+	}
+	if len(added) == 0 {
+		return nil, nil
 	}
-	tree.SetPath([]string{"repositories", *group}, append(members, path))
+	tree.SetPath([]string{"repositories", group}, entries)
 
-	out, err := tree.ToTomlString()
+	out, err := marshalConfiguration(file, tree)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return added, os.WriteFile(file, out, 0644)
+}
+
+// parseInterspersed parses fs but, unlike fs.Parse, carries on past positional
+// arguments so flags may follow them (`branch create NAME --from REF`). It
+// returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+// Anything but y or yes, including no answer at all, is a no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
 	}
-	if err := os.WriteFile(file, []byte(out), 0644); err != nil {
+	return false
+}
+
+// List prints the repositories of the selected groups, group by group, as
+// narrowed by the runner's filters. Groups left empty by a filter are omitted.
+func (runner *runner) List(group string) error {
+	repos, err := filterGroup(runner.repos.ListRepositories(), group)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Added %s to group %q\n", path, *group)
+	for _, key := range sortedKeys(repos) {
+		var members []string
+		for _, repo := range repos[key] {
+			if runner.keep(repo) {
+				members = append(members, repo)
+			}
+		}
+		if len(members) == 0 && len(repos[key]) > 0 {
+			continue
+		}
+		members = runner.expand(members)
+		fmt.Fprintf(runner.writer, "%s:\n", key)
+		for _, repo := range members {
+			fmt.Fprintf(runner.writer, "  - %s\n", repo)
+		}
+	}
 	return nil
 }
 
-// filterGroup narrows the group map to the requested group so `list` previews
+// filterGroup narrows the group map to the requested groups so `list` previews
 // the same repositories `run` would touch, instead of always dumping every
-// group. -g left at its default keeps the whole config; an explicit unknown
-// group is an error, mirroring selectRepositories.
+// group. -g left at its default keeps the whole config. Otherwise every group
+// named by the specifier (all of them for "all") is kept, narrowed to the
+// repositories parallel.SelectRepositories picks; groups left empty are dropped. An
+// explicit unknown group is an error.
 func filterGroup(all map[string][]string, group string) (map[string][]string, error) {
 	if group == "default" {
 		return all, nil
 	}
-	members, found := all[group]
-	if !found {
-		return nil, fmt.Errorf("Unknown group %q, available groups: %s", group, strings.Join(sortedKeys(all), ", "))
+	selected, err := parallel.SelectRepositories(all, group)
+	if err != nil {
+		return nil, err
 	}
-	return map[string][]string{group: members}, nil
+
+	result := make(map[string][]string)
+	for _, name := range parallel.SelectedGroups(all, group) {
+		if _, done := result[name]; done {
+			continue
+		}
+		for _, repo := range all[name] {
+			if slices.Contains(selected, repo) {
+				result[name] = append(result[name], repo)
+			}
+		}
+	}
+	return result, nil
+}
+
+type builtinCommand struct {
+	name, description string
+	// reserved commands cannot be redefined in [commands]. The others were
+	// added later and give way to a configured command of the same name, so a
+	// config written before them keeps running what it says.
+	reserved bool
+}
+
+// builtinCommands are the commands implemented by the tool itself, in the
+// order -h lists them.
+var builtinCommands = []builtinCommand{
+	{"run", "run an arbitrary command", true},
+	{"list", "list repositories where command will be run", true},
+	{"add", "register the current (or given) repository in a group", true},
+	{"import", "add the repositories of a repo manifest, .gitmodules, .mrconfig or path list (stdin) to a group", false},
+	{"grep", "git grep every repository and merge the matches (--json for JSON)", false},
+	{"log", "merge git log of every repository into one timeline (--since, --author, --grep, --json)", false},
+	{"branch", "create, switch, delete or list branches, only once every repository passes preflight checks", false},
+	{"sync", "check out and fast-forward each repository's default branch", false},
+	{"snapshot", "save NAME records branches, HEADs and local changes; restore NAME returns to them", false},
+	{"export", "write the remotes, branch and sha of every repository as a TOML or JSON manifest", false},
+	{"prune-branches", "delete local branches merged into the default branch (--gone: also those whose upstream is gone)", false},
+}
+
+func findBuiltin(name string) (builtinCommand, bool) {
+	for _, builtin := range builtinCommands {
+		if builtin.name == name {
+			return builtin, true
+		}
+	}
+	return builtinCommand{}, false
+}
+
+// shadowed reports whether a command configured in config replaces the
+// built-in command name.
+func shadowed(config *configuration, name string) bool {
+	builtin, found := findBuiltin(name)
+	if !found || builtin.reserved || config == nil {
+		return false
+	}
+	_, configured := config.Command(name)
+	return configured
 }
 
 func listCommands() string {
@@ -240,16 +562,25 @@ func listCommands() string {
 	}
 
 	maxSize := 3
+	for _, builtin := range builtinCommands {
+		maxSize = max(maxSize, len(builtin.name))
+	}
 	for key := range commands {
-		if size := len(key); size > maxSize {
-			maxSize = size
-		}
+		maxSize = max(maxSize, len(key))
 	}
 
-	result := fmt.Sprintf("  %-"+strconv.Itoa(maxSize)+"s	%s\n", "run", "run an arbitrary command")
-	result += fmt.Sprintf("  %-"+strconv.Itoa(maxSize)+"s	%s\n", "list", "list repositories where command will be run")
-	result += fmt.Sprintf("  %-"+strconv.Itoa(maxSize)+"s	%s\n", "add", "register the current (or given) repository in a group")
+	result := ""
+	for _, builtin := range builtinCommands {
+		if shadowed(config, builtin.name) {
+			continue
+		}
+		result += fmt.Sprintf("  %-"+strconv.Itoa(maxSize)+"s	%s\n", builtin.name, builtin.description)
+	}
 	for _, key := range sortedKeys(commands) {
+		if builtin, found := findBuiltin(key); found && builtin.reserved {
+			// Never run: the built-in wins.
+			continue
+		}
 		result += fmt.Sprintf("  %-"+strconv.Itoa(maxSize)+"s	%s\n", key, commands[key])
 	}
 
@@ -259,13 +590,17 @@ func listCommands() string {
 func runCommand(config *configuration, args []string, group string) int {
 	commandName := args[0]
 	var toExec []string
+	var successCodes, changedCodes []int
+	var mustConfirm, writes bool
 	if commandName == "run" {
 		toExec = args[1:]
 	} else {
-		command, ok := config.ListCommands()[commandName]
+		spec, ok := config.Command(commandName)
 		if !ok {
 			log.Fatalf("Unknown command %q, run with -h to list available commands.", commandName)
 		}
+		command := spec.Command
+		successCodes, changedCodes, mustConfirm, writes = spec.SuccessCodes, spec.ChangedCodes, spec.Confirm, spec.Write
 		if needsShell(command) {
 			// A naive split on spaces cannot express quoted arguments, pipes or
 			// chaining, so route these through the shell. User arguments arrive as
@@ -273,22 +608,275 @@ func runCommand(config *configuration, args []string, group string) int {
 			if !strings.Contains(command, "$@") {
 				command += ` "$@"`
 			}
-			// The trailing $@ is a placeholder token forwardArgs expands into the
-			// shell's positional parameters ($1, $2, "$@") after the sh name.
+			// The trailing $@ is a placeholder token parallel.ForwardArgs
+			// expands into the shell's positional parameters ($1, $2, "$@")
+			// after the sh name.
 			toExec = []string{"/bin/sh", "-c", command, "sh", "$@"}
 		} else {
 			toExec = strings.Split(command, " ")
 		}
 	}
 
-	runner := newRunner(&run{ToExec: toExec, Quiet: quiet}, config)
-	runner.jobs = jobs
-	runner.timeout = timeout
+	resolved := parallel.ForwardArgs(toExec, args[1:])
+	runner := configuredRunner(&run{ToExec: toExec, Quiet: quiet, SuccessCodes: successCodes, ChangedCodes: changedCodes}, config)
+	runner.commandNames = []string{commandName}
+	for _, invocation := range gitInvocations(resolved) {
+		runner.commandNames = append(runner.commandNames, invocation[0])
+		writes = writes || writesRepository(invocation)
+	}
+	runner.writes = writes
+
+	if (mustConfirm || isDestructive(resolved)) && !assumeYes {
+		if feedStdin {
+			log.Fatal("This command asks for confirmation, which cannot be read while --stdin is in use: pass --yes.")
+		}
+		repos, err := runner.affected(group)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "About to run `%s` in %d %s:\n", strings.Join(resolved, " "), len(repos), plural(len(repos), "repository", "repositories"))
+		for _, repo := range repos {
+			fmt.Fprintf(os.Stderr, "  - %s\n", repo)
+		}
+		if !confirm("Continue?") {
+			fmt.Fprintln(os.Stderr, "Aborted.")
+			return 1
+		}
+	}
+
 	runner.stream = stream
 	runner.failed = failed
+	runner.interactive = interactive
+	if feedStdin {
+		if interactive {
+			log.Fatal("--stdin cannot be combined with --interactive, which already wires the terminal's stdin.")
+		}
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Cannot read stdin.\n%v", err)
+		}
+		runner.stdin = input
+	}
 	return runner.Run(args[1:], group)
 }
 
+// configuredRunner builds a runner honouring the global -j and -timeout flags,
+// shared by every command that fans out over the repositories.
+func configuredRunner(command runnableCommand, config *configuration) *runner {
+	runner := newRunner(command, config)
+	runner.jobs = jobs
+	runner.timeout = timeout
+	runner.labels = labels
+	runner.exclude = excludes
+	runner.only = only
+	runner.worktrees = worktrees
+	runner.submodules = submodules
+	return runner
+}
+
+// destructiveGit tells, per git subcommand, whether its arguments make it lose
+// work or rewrite shared history.
+var destructiveGit = map[string]func(args []string) bool{
+	"push": anyOption(func(opt string) bool {
+		return opt == "-f" || opt == "--force" || strings.HasPrefix(opt, "--force-with-lease") || strings.HasPrefix(opt, "+")
+	}),
+	"reset":  anyOption(func(opt string) bool { return opt == "--hard" }),
+	"branch": forcedDelete,
+	"clean": anyOption(func(opt string) bool {
+		return opt == "--force" || (strings.HasPrefix(opt, "-") && !strings.HasPrefix(opt, "--") && strings.Contains(opt, "f"))
+	}),
+}
+
+func anyOption(risky func(opt string) bool) func(args []string) bool {
+	return func(args []string) bool {
+		return slices.ContainsFunc(args, risky)
+	}
+}
+
+// forcedDelete reports whether `git branch` deletes branches even when they
+// are not merged: -D, or --delete combined with --force.
+func forcedDelete(args []string) bool {
+	deletes, forced := false, false
+	for _, opt := range args {
+		switch {
+		case opt == "--delete":
+			deletes = true
+		case opt == "--force":
+			forced = true
+		case strings.HasPrefix(opt, "-") && !strings.HasPrefix(opt, "--"):
+			if strings.Contains(opt, "D") {
+				return true
+			}
+			deletes = deletes || strings.Contains(opt, "d")
+			forced = forced || strings.Contains(opt, "f")
+		}
+	}
+	return deletes && forced
+}
+
+// isDestructive reports whether a resolved command line runs a risky git
+// command such as `push --force`, `reset --hard`, `branch -D` or `clean -fd`.
+func isDestructive(toExec []string) bool {
+	for _, invocation := range gitInvocations(toExec) {
+		if risky, found := destructiveGit[invocation[0]]; found && risky(invocation[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// writingGit lists the git subcommands that modify a repository's refs, index
+// or worktree, refused in read-only repositories.
+var writingGit = map[string]bool{
+	"add": true, "am": true, "apply": true, "branch": true, "checkout": true,
+	"cherry-pick": true, "clean": true, "commit": true, "config": true, "gc": true,
+	"merge": true, "mv": true, "notes": true, "pull": true, "push": true,
+	"rebase": true, "remote": true, "reset": true, "restore": true, "revert": true,
+	"rm": true, "stash": true, "submodule": true, "switch": true, "tag": true,
+	"update-ref": true, "worktree": true,
+}
+
+// readingGit recognises, from their arguments, the forms of writing git
+// subcommands that only list or show, such as `branch -r --merged` or
+// `worktree list`.
+var readingGit = map[string]func(args []string) bool{
+	"branch": refListing{
+		writing: []string{"--delete", "--move", "--copy", "--force", "--edit-description", "--set-upstream-to", "--unset-upstream", "--track", "--no-track", "--create-reflog"},
+		listing: []string{"--list", "--all", "--remotes", "--verbose", "--contains", "--no-contains", "--merged", "--no-merged", "--points-at", "--show-current", "--format", "--sort"},
+		short:   "dDmMcCuft", shortListing: "larv",
+	}.reads,
+	"tag": refListing{
+		writing: []string{"--delete", "--annotate", "--sign", "--local-user", "--force", "--message", "--file", "--edit", "--create-reflog"},
+		listing: []string{"--list", "--contains", "--no-contains", "--merged", "--no-merged", "--points-at", "--format", "--sort", "--verify"},
+		short:   "dasufmFe", shortListing: "lnv",
+	}.reads,
+	"worktree":  subcommandIn("list"),
+	"submodule": subcommandIn("", "status", "summary", "foreach"),
+	"notes":     subcommandIn("", "list", "show", "get-ref"),
+	"stash":     subcommandIn("list", "show"),
+	"remote":    subcommandIn("", "show", "get-url", "update"),
+	"config":    configReads,
+}
+
+// refListing tells the listing forms of `git branch` and `git tag` from the
+// ones creating, deleting or changing a ref: without a writing option, they
+// list unless given a name and no listing option.
+type refListing struct {
+	writing, listing    []string
+	short, shortListing string
+}
+
+func (options refListing) reads(args []string) bool {
+	listing, named := false, false
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			if slices.Contains(options.writing, name) {
+				return false
+			}
+			listing = listing || slices.Contains(options.listing, name)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			if strings.ContainsAny(arg[1:], options.short) {
+				return false
+			}
+			listing = listing || strings.ContainsAny(arg[1:], options.shortListing)
+		default:
+			named = true
+		}
+	}
+	return listing || !named
+}
+
+// subcommandIn returns a test telling whether the first non-option argument
+// is one of subcommands, "" standing for none.
+func subcommandIn(subcommands ...string) func(args []string) bool {
+	return func(args []string) bool {
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "-") {
+				return slices.Contains(subcommands, arg)
+			}
+		}
+		return slices.Contains(subcommands, "")
+	}
+}
+
+// configReads tells `git config` reading a value or listing from it setting,
+// unsetting or editing: either through the get and list subcommands, the
+// --get and --list options, or a name given without a value.
+func configReads(args []string) bool {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		name, _, _ := strings.Cut(args[i], "=")
+		switch {
+		case strings.HasPrefix(name, "--get") || name == "--list" || name == "-l":
+			return true
+		case slices.Contains([]string{"--add", "--unset", "--unset-all", "--replace-all", "--rename-section", "--remove-section", "--edit", "-e"}, name):
+			return false
+		case slices.Contains([]string{"--file", "-f", "--blob", "--type", "--default", "--comment", "--value"}, args[i]):
+			// The value comes next.
+			i++
+		case !strings.HasPrefix(name, "-"):
+			positional = append(positional, args[i])
+		}
+	}
+	if len(positional) > 0 {
+		switch positional[0] {
+		case "get", "list":
+			return true
+		case "set", "unset", "rename-section", "remove-section", "edit":
+			return false
+		}
+	}
+	return len(positional) < 2
+}
+
+// writesRepository reports whether a git invocation, its subcommand followed
+// by its arguments, modifies the repository.
+func writesRepository(invocation []string) bool {
+	if reads, found := readingGit[invocation[0]]; found && reads(invocation[1:]) {
+		return false
+	}
+	return writingGit[invocation[0]]
+}
+
+// gitInvocations finds the git commands in a resolved command line and returns
+// each as its subcommand followed by its arguments. Shell commands are cut at
+// separators and every simple command is scanned.
+func gitInvocations(toExec []string) [][]string {
+	var invocations [][]string
+	line := strings.Join(toExec, " ")
+	for _, simple := range strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(";&|\n", r) }) {
+		words := strings.Fields(strings.NewReplacer(`"`, "", "'", "").Replace(simple))
+		for i, word := range words {
+			if filepath.Base(word) != "git" {
+				continue
+			}
+			if invocation := skipGitOptions(words[i+1:]); len(invocation) > 0 {
+				invocations = append(invocations, invocation)
+			}
+		}
+	}
+	return invocations
+}
+
+// gitOptionsWithValue are the options git takes before its subcommand whose
+// value may come as the next argument.
+var gitOptionsWithValue = []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace", "--config-env", "--super-prefix", "--attr-source"}
+
+// skipGitOptions drops the options given to git itself, such as -C DIR or
+// -c NAME=VALUE, so the subcommand comes first.
+func skipGitOptions(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if slices.Contains(gitOptionsWithValue, args[0]) && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	return args
+}
+
 // needsShell reports whether a configured command relies on shell features
 // (quotes, pipes, chaining, redirection, subshells) that a plain space-split
 // cannot honour. The $N/$@ placeholders are deliberately excluded so plain
@@ -297,9 +885,7 @@ func needsShell(command string) bool {
 	return strings.ContainsAny(command, "|&;<>()`\"'")
 }
 
-type repositories interface {
-	ListRepositories() map[string][]string
-}
+type repositories = parallel.Repositories
 
 type configuration struct {
 	content *toml.Tree
@@ -314,7 +900,7 @@ func newConfiguration(file string) *configuration {
 }
 
 func tryNewConfiguration(file string) (*configuration, error) {
-	config, err := toml.LoadFile(file)
+	config, err := loadConfiguration(file, map[string]bool{})
 	if err != nil {
 		return nil, err
 	}
@@ -328,11 +914,143 @@ func (config *configuration) ListRepositories() map[string][]string {
 		return result
 	}
 	for _, key := range repos.Keys() {
-		result[key] = toStringArray(repos.Get(key).([]interface{}))
+		entries := repositoryEntries(repos.Get(key))
+		paths := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if path := repositoryPath(entry); path != "" {
+				paths = append(paths, path)
+			}
+		}
+		result[key] = paths
 	}
 	return result
 }
 
+// repositoryEntries returns the elements of a [repositories] group. go-toml
+// decodes an array made only of inline tables as []*toml.Tree.
+func repositoryEntries(group interface{}) []interface{} {
+	switch group := group.(type) {
+	case []interface{}:
+		return group
+	case []*toml.Tree:
+		entries := make([]interface{}, len(group))
+		for i, entry := range group {
+			entries[i] = entry
+		}
+		return entries
+	}
+	return nil
+}
+
+// repositoryPath reads an element of a [repositories] group: either a plain
+// path or an inline table with a path and attributes, e.g.
+// { path = "/dev/vendor-mirror", readonly = true }.
+func repositoryPath(entry interface{}) string {
+	switch entry := entry.(type) {
+	case string:
+		return entry
+	case *toml.Tree:
+		path, _ := entry.Get("path").(string)
+		return path
+	}
+	return ""
+}
+
+// settings are the attributes of a repository, set on its own entry or on a
+// group listing it:
+//
+//	[groups.vendor]
+//	  readonly = true
+//	  protected_commands = ["push", "merge"]
+type settings struct {
+	// Readonly repositories refuse every command that modifies them.
+	Readonly bool
+	// ProtectedCommands name the commands (configured, built-in or git
+	// subcommands) refused in the repository.
+	ProtectedCommands []string
+	// Env holds the variables added to the environment of the commands run in
+	// the repository, e.g. a JAVA_HOME or GIT_SSH_COMMAND per group.
+	Env map[string]string
+	// Workdir, relative to the repository root, is where non-git commands run,
+	// e.g. the backend/ of a monorepo keeping its build in a subdirectory.
+	Workdir string
+	// Labels are free-form tags selected with -l, on top of groups.
+	Labels []string
+}
+
+func (s *settings) merge(attributes *toml.Tree) {
+	if attributes.Get("readonly") == true {
+		s.Readonly = true
+	}
+	if values, ok := attributes.Get("protected_commands").([]interface{}); ok {
+		s.ProtectedCommands = append(s.ProtectedCommands, toStringArray(values)...)
+	}
+	if workdir, ok := attributes.Get("workdir").(string); ok {
+		s.Workdir = workdir
+	}
+	if values, ok := attributes.Get("labels").([]interface{}); ok {
+		s.Labels = append(s.Labels, toStringArray(values)...)
+	}
+	s.mergeEnv(attributes)
+}
+
+func (s *settings) mergeEnv(attributes *toml.Tree) {
+	env, ok := attributes.Get("env").(*toml.Tree)
+	if !ok {
+		return
+	}
+	if s.Env == nil {
+		s.Env = make(map[string]string)
+	}
+	for _, key := range env.Keys() {
+		s.Env[key] = fmt.Sprint(env.Get(key))
+	}
+}
+
+// Settings returns the attributes of the repository at path, merged from the
+// top-level [env] table, the [groups.NAME] table of every group listing it, then its
+// own entries: a variable set on the repository wins over its groups', which
+// win over the global one. A repository is read-only, or a command protected,
+// as soon as one of them says so.
+func (config *configuration) Settings(path string) settings {
+	var result settings
+	result.mergeEnv(config.content)
+	repos, ok := config.content.Get("repositories").(*toml.Tree)
+	if !ok {
+		return result
+	}
+	// Keys are sorted so a variable set by two groups resolves the same way on
+	// every run.
+	var entries []*toml.Tree
+	for _, group := range sortedKeys(toSet(repos.Keys())) {
+		listed := false
+		for _, entry := range repositoryEntries(repos.Get(group)) {
+			if repositoryPath(entry) != path {
+				continue
+			}
+			listed = true
+			if attributes, ok := entry.(*toml.Tree); ok {
+				entries = append(entries, attributes)
+			}
+		}
+		if attributes, ok := config.content.GetPath([]string{"groups", group}).(*toml.Tree); ok && listed {
+			result.merge(attributes)
+		}
+	}
+	for _, attributes := range entries {
+		result.merge(attributes)
+	}
+	return result
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
 func sortedKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for key := range m {
@@ -343,9 +1061,11 @@ func sortedKeys[V any](m map[string]V) []string {
 }
 
 func toStringArray(values []interface{}) []string {
-	result := make([]string, len(values))
-	for i, value := range values {
-		result[i] = value.(string)
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			result = append(result, value)
+		}
 	}
 	return result
 }
@@ -357,17 +1077,100 @@ func (config *configuration) ListCommands() map[string]string {
 		return result
 	}
 	for _, key := range all.Keys() {
-		result[key] = all.Get(key).(string)
+		if spec, ok := config.Command(key); ok {
+			result[key] = spec.Command
+		}
+	}
+	return result
+}
+
+// commandSpec is a configured command. Most are a plain string, shorthand for
+// a spec with only Command set; a table also declares which exit codes are not
+// failures:
+//
+//	[commands.dirty]
+//	  command = "git diff --quiet"
+//	  changed_codes = [1]
+type commandSpec struct {
+	Command string
+	// SuccessCodes are non-zero exit codes reported as success, e.g. grep's 1
+	// for "no match".
+	SuccessCodes []int
+	// ChangedCodes are exit codes reported as a distinct "changed" state, e.g.
+	// `git diff --quiet` exiting 1 when the worktree has changes.
+	ChangedCodes []int
+	// Confirm asks for confirmation before running the command, as is done
+	// automatically for destructive git commands.
+	Confirm bool
+	// Write marks a command modifying the repositories, refused in read-only
+	// ones. Git commands that write are recognised without it.
+	Write bool
+}
+
+// Command looks up a configured command by name.
+func (config *configuration) Command(name string) (commandSpec, bool) {
+	switch value := config.content.GetPath([]string{"commands", name}).(type) {
+	case string:
+		return commandSpec{Command: value}, true
+	case *toml.Tree:
+		command, ok := value.Get("command").(string)
+		if !ok {
+			return commandSpec{}, false
+		}
+		return commandSpec{
+			Command:      command,
+			SuccessCodes: toIntArray(value.Get("success_codes")),
+			ChangedCodes: toIntArray(value.Get("changed_codes")),
+			Confirm:      value.Get("confirm") == true,
+			Write:        value.Get("write") == true,
+		}, true
+	}
+	return commandSpec{}, false
+}
+
+func toIntArray(value interface{}) []int {
+	values, _ := value.([]interface{})
+	result := make([]int, 0, len(values))
+	for _, value := range values {
+		if n, ok := value.(int64); ok {
+			result = append(result, int(n))
+		}
 	}
 	return result
 }
 
 type runnableCommand interface {
-	Executable() string
-	Options() []string
+	parallel.Command
 	Output(output string, err error) string
 }
 
+// status is the outcome of a command in one repository. Only statusFailed
+// counts towards the exit code.
+type status int
+
+const (
+	statusOK status = iota
+	statusChanged
+	statusFailed
+)
+
+// classifier is implemented by commands for which some non-zero exit codes
+// mean success or "changed" rather than failure. Commands that don't implement
+// it fail on any error.
+type classifier interface {
+	Status(err error) status
+}
+
+func (runner *runner) status(err error) status {
+	if c, ok := runner.runnableCommand.(classifier); ok {
+		return c.Status(err)
+	}
+	if err != nil {
+		return statusFailed
+	}
+	return statusOK
+}
+
 type runner struct {
 	runnableCommand
 
@@ -377,6 +1180,32 @@ type runner struct {
 	timeout time.Duration
 	stream  bool
 	failed  bool
+	// interactive runs repositories sequentially with the child attached to the
+	// real terminal instead of buffered pipes.
+	interactive bool
+	// commandNames identify the command for protected_commands: its name as
+	// typed and the git subcommands it runs.
+	commandNames []string
+	// writes tells whether the command modifies repositories, which read-only
+	// repositories refuse.
+	writes bool
+	// labels is the -l expression repositories must match to be selected.
+	labels string
+	// exclude lists the paths or directory names of repositories left out.
+	exclude []string
+	// only, when set, keeps just the repositories matching one of its -r
+	// patterns.
+	only []string
+	// worktrees and submodules expand each selected repository into its
+	// linked worktrees and its submodules.
+	worktrees  bool
+	submodules bool
+	// origins maps the paths added by expand to the repository they were
+	// found in, whose settings they share.
+	origins map[string]string
+	// stdin, when non-nil, is fed to every child process. It is read once up
+	// front since os.Stdin itself can only be consumed by a single reader.
+	stdin []byte
 	// mu serialises writes to writer so lines from different repositories in
 	// stream mode land whole instead of interleaved mid-line.
 	mu sync.Mutex
@@ -392,112 +1221,364 @@ func newRunner(command runnableCommand, repos repositories) *runner {
 }
 
 func (runner *runner) Run(args []string, group string) int {
-	var failures atomic.Int32
-	wg := sync.WaitGroup{}
-	repos, err := selectRepositories(runner.repos.ListRepositories(), group)
+	repos, err := runner.selected(group)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
 
-	// forwardArgs is deterministic, so compute the argument list once instead of
-	// once per goroutine.
-	argv := forwardArgs(runner.runnableCommand.Options(), args)
+	// parallel.ForwardArgs is deterministic, so compute the argument list once
+	// instead of once per goroutine.
+	argv := parallel.ForwardArgs(runner.runnableCommand.Options(), args)
+
+	if runner.interactive {
+		return runner.runInteractive(repos, argv)
+	}
 
-	// Bound the number of concurrent child processes: without a limit, a large
-	// group spawns one git process per repository at once, thrashing disk and
-	// tripping server-side limits on concurrent SSH connections.
-	limit := runner.jobs
-	if limit < 1 {
-		limit = 1
+	var failures, changes atomic.Int32
+	// report counts the outcome of a command and tells whether to print it:
+	// --failed drops the per-repo line for successes so the few failures
+	// aren't buried under a wall of ✔ across dozens of repositories.
+	report := func(err error) bool {
+		status := runner.status(err)
+		switch status {
+		case statusFailed:
+			failures.Add(1)
+		case statusChanged:
+			changes.Add(1)
+		}
+		return !runner.failed || status == statusFailed
+	}
+
+	if !runner.stream {
+		engine := runner.engine()
+		engine.Expand = runner.expandRepository
+		engine.Prepare = runner.prepare
+		// stdout and stderr in one buffer keep the order the command wrote them.
+		engine.Combined = true
+		// Each repository is printed as soon as it is done, so a slow one does
+		// not hold back the others' results.
+		engine.OnResult = func(result parallel.Result) {
+			if report(result.Err) {
+				fmt.Fprintln(runner.writer, filepath.Base(result.Repository)+": "+runner.runnableCommand.Output(strings.TrimSpace(result.Stdout), result.Err))
+			}
+		}
+		engine.Run(context.Background(), repos, runner.runnableCommand, args...)
+
+		failed := int(failures.Load())
+		runner.summary(len(repos), int(changes.Load()), failed)
+		return failed
 	}
-	sem := make(chan struct{}, limit)
 
 	// Align stream prefixes on the longest repository name so the ` | ` gutters
 	// line up regardless of which repo emits a line.
 	width := 0
-	if runner.stream {
-		for _, repo := range repos {
-			if n := len(filepath.Base(repo)); n > width {
-				width = n
-			}
+	for _, repo := range repos {
+		if n := len(filepath.Base(repo)); n > width {
+			width = n
+		}
+	}
+
+	runner.each(repos, func(ctx context.Context, _ int, repo string) {
+		repoArgv, err := runner.expandRepository(ctx, repo, argv)
+		command := runner.command(ctx, repo, repoArgv)
+		prefixed := &prefixWriter{
+			prefix: fmt.Sprintf("%-*s | ", width, filepath.Base(repo)),
+			mu:     &runner.mu,
+			out:    runner.writer,
+		}
+		// stdout and stderr share one writer: os/exec then guarantees at most
+		// one goroutine calls Write at a time, so prefixed.buf needs no lock.
+		command.Stdout = prefixed
+		command.Stderr = prefixed
+
+		if err == nil {
+			err = runner.execute(ctx, command)
 		}
+		if !report(err) {
+			return
+		}
+
+		prefixed.flush()
+		// Output was already streamed live, so the summary only reports the
+		// final ✔/✘ status rather than re-dumping it.
+		runner.mu.Lock()
+		fmt.Fprintln(runner.writer, filepath.Base(repo)+": "+runner.runnableCommand.Output("", err))
+		runner.mu.Unlock()
+	})
+
+	failed := int(failures.Load())
+	runner.summary(len(repos), int(changes.Load()), failed)
+
+	return failed
+}
+
+// repositorySettings is implemented by configurations carrying per-repository
+// attributes on top of the group lists.
+type repositorySettings interface {
+	Settings(path string) settings
+}
+
+func (runner *runner) settings(repo string) settings {
+	if origin, found := runner.origins[repo]; found {
+		repo = origin
+	}
+	if config, ok := runner.repos.(repositorySettings); ok {
+		return config.Settings(repo)
 	}
+	return settings{}
+}
+
+// dir returns where argv runs in repo: the configured workdir, except for git
+// commands which keep operating on the whole repository from its root.
+func (runner *runner) dir(repo string, argv []string) string {
+	workdir := runner.settings(repo).Workdir
+	if workdir == "" || isGitCommand(runner.runnableCommand.Executable(), argv) {
+		return repo
+	}
+	return filepath.Join(repo, workdir)
+}
+
+// isGitCommand reports whether a command line starts with git, either directly
+// or as the first word of a shell script.
+func isGitCommand(executable string, argv []string) bool {
+	if filepath.Base(executable) == "git" {
+		return true
+	}
+	if len(argv) > 1 && argv[0] == "-c" {
+		words := strings.Fields(argv[1])
+		return len(words) > 0 && filepath.Base(words[0]) == "git"
+	}
+	return false
+}
+
+// environ returns the environment of the commands run in repo: the tool's own,
+// overridden by the variables configured for the repository.
+func (runner *runner) environ(repo string) []string {
+	env := runner.settings(repo).Env
+	result := os.Environ()
+	for _, key := range sortedKeys(env) {
+		result = append(result, key+"="+env[key])
+	}
+	return result
+}
 
+// selection resolves the group specifier with parallel.SelectRepositories,
+// keeps the repositories passing the runner's filters and expands them into
+// their worktrees and submodules when asked to.
+func (runner *runner) selection(group string) ([]string, error) {
+	repos, err := parallel.SelectRepositories(runner.repos.ListRepositories(), group)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]string, 0, len(repos))
 	for _, repo := range repos {
-		wg.Add(1)
-		go func(repo string) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			// Time the timeout from when the command actually starts, not when it
-			// was queued, so repos waiting on the semaphore don't burn their budget.
-			ctx := context.Background()
-			if runner.timeout > 0 {
-				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(ctx, runner.timeout)
-				defer cancel()
-			}
+		if runner.keep(repo) {
+			kept = append(kept, repo)
+		}
+	}
+	return runner.expand(kept), nil
+}
 
-			command := exec.CommandContext(ctx, runner.runnableCommand.Executable(), argv...)
-			// Stop git blocking on a credential prompt (it reads /dev/tty even when
-			// Stdin isn't wired); it fails fast instead. No effect on other commands.
-			command.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-			command.Dir = repo
-
-			var output *bytes.Buffer
-			var prefixed *prefixWriter
-			if runner.stream {
-				prefixed = &prefixWriter{
-					prefix: fmt.Sprintf("%-*s | ", width, filepath.Base(repo)),
-					mu:     &runner.mu,
-					out:    runner.writer,
-				}
-				// stdout and stderr share one writer: os/exec then guarantees at most
-				// one goroutine calls Write at a time, so prefixed.buf needs no lock.
-				command.Stdout = prefixed
-				command.Stderr = prefixed
-			} else {
-				output = new(bytes.Buffer)
-				command.Stdout = output
-				command.Stderr = output
-			}
+// keep reports whether repo passes the runner's filters: the -l label
+// expression, which cuts across groups by language, owner or deploy tier, the
+// --exclude list and the -r patterns targeting individual repositories.
+func (runner *runner) keep(repo string) bool {
+	for _, excluded := range runner.exclude {
+		if matchRepository(excluded, repo) {
+			return false
+		}
+	}
+	if len(runner.only) > 0 && !slices.ContainsFunc(runner.only, func(pattern string) bool { return matchRepository(pattern, repo) }) {
+		return false
+	}
+	return runner.labels == "" || matchLabels(runner.labels, runner.settings(repo).Labels)
+}
 
-			err := command.Run()
-			if ctx.Err() == context.DeadlineExceeded {
-				err = fmt.Errorf("timed out after %s", runner.timeout)
+// matchLabels evaluates a label expression against a repository's labels.
+// Comma-separated terms must all hold; a term holds when any of its
+// |-separated alternatives does, an alternative being a label, or a label
+// prefixed with ! that the repository must not have. "java|kotlin,!legacy"
+// selects the JVM repositories that are not legacy.
+func matchLabels(expr string, labels []string) bool {
+	for _, term := range strings.Split(expr, ",") {
+		holds := false
+		for _, alternative := range strings.Split(term, "|") {
+			alternative = strings.TrimSpace(alternative)
+			if label, negated := strings.CutPrefix(alternative, "!"); negated {
+				holds = !slices.Contains(labels, label)
+			} else {
+				holds = slices.Contains(labels, alternative)
 			}
-			if err != nil {
-				failures.Add(1)
+			if holds {
+				break
 			}
+		}
+		if !holds {
+			return false
+		}
+	}
+	return true
+}
 
-			// --failed drops the per-repo line for successes so the few failures
-			// aren't buried under a wall of ✔ across dozens of repositories.
-			if runner.failed && err == nil {
-				return
-			}
+// selected is the selection minus the repositories the command must not
+// touch, which are reported as skipped. Vendor mirrors or shared release
+// clones stay in their groups for status and fetch but are never modified by a
+// bulk operation.
+func (runner *runner) selected(group string) ([]string, error) {
+	repos, err := runner.selection(group)
+	if err != nil {
+		return nil, err
+	}
+	return runner.skipProtected(repos), nil
+}
 
-			if runner.stream {
-				prefixed.flush()
-				// Output was already streamed live, so the summary only reports the
-				// final ✔/✘ status rather than re-dumping it.
-				runner.mu.Lock()
-				fmt.Fprintln(runner.writer, filepath.Base(repo)+": "+runner.runnableCommand.Output("", err))
-				runner.mu.Unlock()
-			} else {
-				fmt.Fprintln(runner.writer, filepath.Base(repo)+": "+runner.runnableCommand.Output(strings.TrimSpace(output.String()), err))
+// affected is the selection minus the protected repositories, listed when
+// asking for confirmation since the command will skip them anyway.
+func (runner *runner) affected(group string) ([]string, error) {
+	repos, err := runner.selection(group)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(repos, runner.isProtected), nil
+}
+
+func (runner *runner) skipProtected(repos []string) []string {
+	allowed := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		if runner.isProtected(repo) {
+			if !runner.failed {
+				fmt.Fprintf(runner.writer, "%s: skipped (protected)\n", filepath.Base(repo))
 			}
-		}(repo)
+			continue
+		}
+		allowed = append(allowed, repo)
 	}
-	wg.Wait()
+	return allowed
+}
 
-	failed := int(failures.Load())
-	if runner.failed {
-		fmt.Fprintf(runner.writer, "\n%d %s / %d %s\n", len(repos)-failed, ok, failed, ko)
+func (runner *runner) isProtected(repo string) bool {
+	settings := runner.settings(repo)
+	if settings.Readonly && runner.writes {
+		return true
 	}
+	for _, name := range runner.commandNames {
+		if slices.Contains(settings.ProtectedCommands, name) {
+			return true
+		}
+	}
+	return false
+}
 
-	return failed
+// each calls fn for every repository, at most runner.jobs at a time, with a
+// context carrying runner.timeout.
+func (runner *runner) each(repos []string, fn func(ctx context.Context, i int, repo string)) {
+	runner.engine().Each(context.Background(), repos, fn)
+}
+
+// engine returns the parallel runner carrying out the runner's jobs and
+// timeout.
+func (runner *runner) engine() *parallel.Runner {
+	return &parallel.Runner{Jobs: runner.jobs, Timeout: runner.timeout}
+}
+
+// command prepares the runner's command for one repository. The caller wires
+// its output.
+func (runner *runner) command(ctx context.Context, repo string, argv []string) *exec.Cmd {
+	command := exec.CommandContext(ctx, runner.runnableCommand.Executable(), argv...)
+	runner.prepare(command, repo)
+	return command
+}
+
+// prepare sets command up to run in repo, with the repository's environment
+// and directory and the input fed with --stdin.
+func (runner *runner) prepare(command *exec.Cmd, repo string) {
+	// Stop git blocking on a credential prompt (it reads /dev/tty even when
+	// Stdin isn't wired); it fails fast instead. No effect on other commands.
+	command.Env = append(runner.environ(repo), "GIT_TERMINAL_PROMPT=0")
+	command.Dir = runner.dir(repo, command.Args[1:])
+	if runner.stdin != nil {
+		command.Stdin = bytes.NewReader(runner.stdin)
+	}
+}
+
+// git runs a git command in repo on behalf of a built-in command and returns
+// its trimmed stdout. What git printed on stderr, usually the only useful
+// part, replaces the bare "exit status N" error.
+func (runner *runner) git(ctx context.Context, repo string, args ...string) (string, error) {
+	command := exec.CommandContext(ctx, "git", args...)
+	command.Env = append(runner.environ(repo), "GIT_TERMINAL_PROMPT=0")
+	command.Dir = repo
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	err := runner.execute(ctx, command)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && stderr.Len() > 0 {
+		err = errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// execute runs command and reports a timeout as such rather than as the
+// "signal: killed" the child dies with.
+func (runner *runner) execute(ctx context.Context, command *exec.Cmd) error {
+	return runner.engine().Execute(ctx, command)
+}
+
+// runInteractive runs the command in each repository in turn with the child
+// wired to the real stdin/stdout/stderr, so `git rebase -i`, `git add -p` or
+// credential prompts reach the user. Prompts are therefore not suppressed and
+// no timeout applies: the user is at the keyboard. A header announces each
+// repository before its command takes over the terminal.
+func (runner *runner) runInteractive(repos []string, argv []string) int {
+	header := color.New(color.Bold).SprintfFunc()
+	failures, changes := 0, 0
+	for i, repo := range repos {
+		if i > 0 {
+			fmt.Fprintln(runner.writer)
+		}
+		fmt.Fprintln(runner.writer, header("==> %s (%s)", filepath.Base(repo), repo))
+
+		repoArgv, err := runner.expandRepository(context.Background(), repo, argv)
+		command := exec.Command(runner.runnableCommand.Executable(), repoArgv...)
+		command.Env = runner.environ(repo)
+		command.Dir = runner.dir(repo, repoArgv)
+		command.Stdin = os.Stdin
+		command.Stdout = runner.writer
+		command.Stderr = os.Stderr
+
+		if err == nil {
+			err = command.Run()
+		}
+		status := runner.status(err)
+		switch status {
+		case statusFailed:
+			failures++
+		case statusChanged:
+			changes++
+		}
+		if runner.failed && status != statusFailed {
+			continue
+		}
+		fmt.Fprintln(runner.writer, filepath.Base(repo)+": "+runner.runnableCommand.Output("", err))
+	}
+
+	runner.summary(len(repos), changes, failures)
+	return failures
+}
+
+// summary prints the ✔/✘ count line closing --failed output. It is also shown
+// whenever repositories ended in the "changed" state, whose count is the point
+// of commands such as `git diff --quiet`.
+func (runner *runner) summary(total, changes, failures int) {
+	switch {
+	case changes > 0:
+		fmt.Fprintf(runner.writer, "\n%d %s / %d %s / %d %s\n", total-changes-failures, ok, changes, changedMark, failures, ko)
+	case runner.failed:
+		fmt.Fprintf(runner.writer, "\n%d %s / %d %s\n", total-failures, ok, failures, ko)
+	}
 }
 
 // prefixWriter turns a stream of arbitrary write chunks into whole prefixed
@@ -537,62 +1618,41 @@ func (p *prefixWriter) emit(line []byte) {
 	fmt.Fprintf(p.out, "%s%s\n", p.prefix, line)
 }
 
-// selectRepositories resolves a group specifier into the deduplicated list of
-// repositories to run against. The specifier may name several comma-separated
-// groups, and the special value "all" selects every group. A repository listed
-// in more than one selected group is kept once, in first-seen order. An unknown
-// group name is an error.
-func selectRepositories(all map[string][]string, group string) ([]string, error) {
-	names := strings.Split(group, ",")
-	if group == "all" {
-		names = sortedKeys(all)
-	}
-
-	seen := make(map[string]struct{})
-	var repos []string
-	for _, name := range names {
-		members, found := all[name]
-		if !found {
-			return nil, fmt.Errorf("Unknown group %q, available groups: %s", name, strings.Join(sortedKeys(all), ", "))
-		}
-		for _, repo := range members {
-			if _, dup := seen[repo]; dup {
-				continue
-			}
-			seen[repo] = struct{}{}
-			repos = append(repos, repo)
-		}
-	}
-	return repos, nil
-}
-
-var option = regexp.MustCompile(`\$([0-9]+)`)
-
-func forwardArgs(opts []string, args []string) []string {
-	result := make([]string, 0)
-	for _, opt := range opts {
-		if opt == "$@" {
-			result = append(result, args...)
-		} else if option.MatchString(opt) {
-			result = append(result, option.ReplaceAllStringFunc(opt, func(substring string) string {
-				index, _ := strconv.Atoi(substring[1:])
-				// Leave the placeholder untouched when no matching argument was
-				// provided, rather than panicking on an out-of-range index.
-				if index < 1 || index > len(args) {
-					return substring
+// repoPlaceholder matches the {repo.*} placeholders expanded per repository.
+var repoPlaceholder = regexp.MustCompile(`\{repo\.([a-z_]+)\}`)
+
+// expandRepository replaces {repo.name}, {repo.path} and {repo.default_branch}
+// in argv for one repository, so a single configured command can target each
+// repository's own default branch. The default branch is only detected when an
+// argument asks for it; unknown placeholders are left untouched.
+func (runner *runner) expandRepository(ctx context.Context, repo string, argv []string) ([]string, error) {
+	var err error
+	result := make([]string, len(argv))
+	for i, arg := range argv {
+		result[i] = repoPlaceholder.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			switch placeholder {
+			case "{repo.name}":
+				return filepath.Base(repo)
+			case "{repo.path}":
+				return repo
+			case "{repo.default_branch}":
+				branch, detectErr := runner.defaultBranch(ctx, repo)
+				if detectErr != nil {
+					err = detectErr
 				}
-				return args[index-1]
-			}))
-		} else {
-			result = append(result, opt)
-		}
+				return branch
+			}
+			return placeholder
+		})
 	}
-	return result
+	return result, err
 }
 
 type run struct {
-	ToExec []string
-	Quiet  bool
+	ToExec       []string
+	Quiet        bool
+	SuccessCodes []int
+	ChangedCodes []int
 }
 
 func (command *run) Executable() string {
@@ -603,7 +1663,31 @@ func (command *run) Options() []string {
 	return command.ToExec[1:]
 }
 
+func (command *run) Status(err error) status {
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return statusOK
+	case !errors.As(err, &exit):
+		return statusFailed
+	case slices.Contains(command.SuccessCodes, exit.ExitCode()):
+		return statusOK
+	case slices.Contains(command.ChangedCodes, exit.ExitCode()):
+		return statusChanged
+	}
+	return statusFailed
+}
+
 func (command *run) Output(output string, err error) string {
+	switch command.Status(err) {
+	case statusOK:
+		err = nil
+	case statusChanged:
+		if output == "" || command.Quiet {
+			return changedMark
+		}
+		return fmt.Sprintf("%s\n  %s", changedMark, output)
+	}
 	if err != nil {
 		if output == "" {
 			return fmt.Sprintf("%s\n  %v", ko, err)
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/fatih/color"
 )
 
 func assertEqual(t *testing.T, got, want string) {
@@ -48,6 +52,55 @@ func (config *SingleTempRepository) Dir() string {
 	return filepath.Base(config.tempDir)
 }
 
+// staticRepositories serves a fixed group map, for tests that prepare their
+// repositories up front.
+type staticRepositories map[string][]string
+
+func (repos staticRepositories) ListRepositories() map[string][]string {
+	return repos
+}
+
+// gitRepository creates a Git repository in a temporary directory named name,
+// with files committed on its default branch.
+func gitRepository(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "init", "-q", "-b", "main")
+	for path, content := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "--allow-empty", "-m", "initial")
+	return dir
+}
+
+// git runs a git command in dir with a fixed identity and returns its output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	command := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	command.Dir = dir
+	out, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testRunner returns a runner for command over repos, writing to the returned
+// buffer instead of the standard output.
+func testRunner(command runnableCommand, repos repositories) (*runner, *bytes.Buffer) {
+	output := new(bytes.Buffer)
+	runner := newRunner(command, repos)
+	runner.writer = output
+	return runner, output
+}
+
 type PrintArgumentsCommand struct{}
 
 func (command *PrintArgumentsCommand) Executable() string {
@@ -165,7 +218,7 @@ func TestRunFailedHidesSuccessesAndPrintsSummary(t *testing.T) {
 	if strings.Contains(output.String(), repos.Dir()) {
 		t.Errorf("--failed should hide successful repositories, got %q", output.String())
 	}
-	if !strings.Contains(output.String(), "1 ✔ / 0 ✘") {
+	if !strings.Contains(output.String(), fmt.Sprintf("1 %s / 0 %s", ok, ko)) {
 		t.Errorf("expected a ✔/✘ summary line, got %q", output.String())
 	}
 }
@@ -182,11 +235,47 @@ func TestRunFailedKeepsFailingRepositories(t *testing.T) {
 	if !strings.Contains(output.String(), repos.Dir()) {
 		t.Errorf("--failed should keep failing repositories, got %q", output.String())
 	}
-	if !strings.Contains(output.String(), "0 ✔ / 1 ✘") {
+	if !strings.Contains(output.String(), fmt.Sprintf("0 %s / 1 %s", ok, ko)) {
 		t.Errorf("expected a ✔/✘ summary line, got %q", output.String())
 	}
 }
 
+func TestRunReportsConfiguredExitCodes(t *testing.T) {
+	repos := &SingleTempRepository{}
+
+	runner, output := testRunner(&run{ToExec: []string{"/bin/sh", "-c", "echo dirty; exit 1"}, ChangedCodes: []int{1}}, repos)
+
+	if failures := runner.Run(nil, "default"); failures != 0 {
+		t.Errorf("got %d failures for a changed exit code, want 0", failures)
+	}
+	if !strings.Contains(output.String(), repos.Dir()+": "+changedMark+"\n  dirty") {
+		t.Errorf("expected the changed marker and output, got %q", output.String())
+	}
+	if !strings.Contains(output.String(), fmt.Sprintf("0 %s / 1 %s / 0 %s", ok, changedMark, ko)) {
+		t.Errorf("expected a summary counting the change, got %q", output.String())
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	exitWith := func(code string) error {
+		return exec.Command("/bin/sh", "-c", "exit "+code).Run()
+	}
+	command := &run{SuccessCodes: []int{1}, ChangedCodes: []int{2}}
+
+	if got := command.Status(nil); got != statusOK {
+		t.Errorf("nil error: got %v, want statusOK", got)
+	}
+	if got := command.Status(exitWith("1")); got != statusOK {
+		t.Errorf("success code: got %v, want statusOK", got)
+	}
+	if got := command.Status(exitWith("2")); got != statusChanged {
+		t.Errorf("changed code: got %v, want statusChanged", got)
+	}
+	if got := command.Status(exitWith("3")); got != statusFailed {
+		t.Errorf("other code: got %v, want statusFailed", got)
+	}
+}
+
 type MultiRepository struct {
 	dirs []string
 }
@@ -213,6 +302,50 @@ func TestRunProcessesEveryRepoWhenParallelismIsBounded(t *testing.T) {
 	}
 }
 
+func TestRunInteractiveRunsRepositoriesInOrderWithHeaders(t *testing.T) {
+	repos := &MultiRepository{}
+	runner, output := testRunner(&PrintArgumentsCommand{}, repos)
+	runner.interactive = true
+
+	if failures := runner.Run([]string{"hello"}, "default"); failures != 0 {
+		t.Errorf("got %d failures, want 0", failures)
+	}
+
+	// Headers appear in configuration order, each followed by its command's
+	// output written straight to the terminal.
+	last := -1
+	for _, dir := range repos.dirs {
+		i := strings.Index(output.String(), "==> "+filepath.Base(dir))
+		if i <= last {
+			t.Fatalf("expected a header for %s after offset %d, got %q", dir, last, output.String())
+		}
+		last = i
+	}
+	if got := strings.Count(output.String(), "hello\n"); got != 5 {
+		t.Errorf("got %d outputs, want 5", got)
+	}
+}
+
+type CatCommand struct{}
+
+func (command *CatCommand) Executable() string { return "cat" }
+
+func (command *CatCommand) Options() []string { return []string{} }
+
+func (command *CatCommand) Output(output string, err error) string { return output }
+
+func TestRunFeedsStdinToEveryRepository(t *testing.T) {
+	repos := &MultiRepository{}
+	runner, output := testRunner(&CatCommand{}, repos)
+	runner.stdin = []byte("same patch")
+
+	runner.Run(nil, "default")
+
+	if got := strings.Count(output.String(), "same patch"); got != 5 {
+		t.Errorf("got stdin in %d repos, want 5: %q", got, output.String())
+	}
+}
+
 type SleepCommand struct{}
 
 func (command *SleepCommand) Executable() string {
@@ -245,36 +378,62 @@ func TestRunTimesOutAHungCommand(t *testing.T) {
 	}
 }
 
-func TestSelectRepositories(t *testing.T) {
-	all := map[string][]string{
-		"default":  {"/a", "/b"},
-		"notifier": {"/b", "/c"},
-		"maven":    {"/d"},
-	}
+func TestSetColor(t *testing.T) {
+	saved := color.NoColor
+	defer func() {
+		color.NoColor = saved
+		ok, ko, changedMark = markers()
+	}()
 
-	if _, err := selectRepositories(all, "nope"); err == nil {
-		t.Error("expected an error for an unknown group")
+	if err := setColor("never"); err != nil {
+		t.Fatal(err)
 	}
+	assertEqual(t, ok, "OK")
+	assertEqual(t, ko, "FAIL")
+	assertEqual(t, changedMark, "CHANGED")
 
-	comma, err := selectRepositories(all, "notifier,maven")
-	if err != nil {
+	if err := setColor("always"); err != nil {
 		t.Fatal(err)
 	}
-	assertEqual(t, strings.Join(comma, ","), "/b,/c,/d")
+	if !strings.Contains(ok, "✔") || !strings.Contains(ok, "\x1b[") {
+		t.Errorf("expected a coloured check mark, got %q", ok)
+	}
 
-	// A repo shared by two selected groups is kept once.
-	shared, err := selectRepositories(all, "default,notifier")
-	if err != nil {
-		t.Fatal(err)
+	if err := setColor("sometimes"); err == nil {
+		t.Error("expected an error for an unknown color mode")
+	}
+}
+
+func TestOnlyTargetsRepositoriesByNameOrRegexp(t *testing.T) {
+	runner, output := testRunner(nil, staticRepositories{"default": {"/dev/maven-color", "/dev/maven-notifier", "/dev/gradle-notifier"}})
+
+	runner.only = []string{"maven-color"}
+	selected, _ := runner.selection("default")
+	assertEqual(t, strings.Join(selected, ","), "/dev/maven-color")
+
+	runner.only = []string{"/notifier$/", "/dev/maven-color"}
+	selected, _ = runner.selection("default")
+	assertEqual(t, strings.Join(selected, ","), "/dev/maven-color,/dev/maven-notifier,/dev/gradle-notifier")
+
+	runner.only = []string{"/^gradle/"}
+	runner.List("default")
+	assertEqual(t, output.String(), "default:\n  - /dev/gradle-notifier\n")
+
+	var patterns repositoryPatterns
+	if err := patterns.Set("/[/"); err == nil {
+		t.Error("expected an error for an invalid regular expression")
 	}
-	assertEqual(t, strings.Join(shared, ","), "/a,/b,/c")
+}
+
+func TestExcludeLeavesOutRepositoriesByPathOrName(t *testing.T) {
+	runner := newRunner(nil, staticRepositories{"default": {"/dev/maven-color", "/dev/maven-notifier", "/dev/archived"}})
+	runner.exclude = []string{"maven-color", "/dev/archived"}
 
-	// "all" fans out over every group, deterministically and deduplicated.
-	every, err := selectRepositories(all, "all")
+	selected, err := runner.selection("default")
 	if err != nil {
 		t.Fatal(err)
 	}
-	assertEqual(t, strings.Join(every, ","), "/a,/b,/d,/c")
+	assertEqual(t, strings.Join(selected, ","), "/dev/maven-notifier")
 }
 
 func TestFilterGroup(t *testing.T) {
@@ -297,6 +456,12 @@ func TestFilterGroup(t *testing.T) {
 	if _, err := filterGroup(all, "nope"); err == nil {
 		t.Error("expected an error for an unknown group")
 	}
+
+	// Exclusions narrow the listed groups, dropping emptied ones.
+	except := mustFilter(t, all, "all,-default")
+	if len(except) != 1 || strings.Join(except["notifier"], ",") != "/b,/c" {
+		t.Errorf("expected only the notifier group, got %v", except)
+	}
 }
 
 func mustFilter(t *testing.T, all map[string][]string, group string) map[string][]string {
@@ -308,11 +473,52 @@ func mustFilter(t *testing.T, all map[string][]string, group string) map[string]
 	return got
 }
 
-func TestForwardArgsLeavesPlaceholderWhenArgumentIsMissing(t *testing.T) {
-	result := forwardArgs([]string{"$1", "$3"}, []string{"only-first"})
+func TestExpandRepositoryPlaceholders(t *testing.T) {
+	repo := gitRepository(t, "maven-color", nil)
+	runner := newRunner(nil, staticRepositories{})
 
-	assertEqual(t, result[0], "only-first")
-	assertEqual(t, result[1], "$3")
+	argv, err := runner.expandRepository(t.Context(), repo, []string{"{repo.name}", "origin/{repo.default_branch}", "{repo.unknown}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(argv, " "), "maven-color origin/main {repo.unknown}")
+
+	if _, err := runner.expandRepository(t.Context(), t.TempDir(), []string{"{repo.default_branch}"}); err == nil {
+		t.Error("expected an error when the default branch cannot be detected")
+	}
+}
+
+func TestIsDestructive(t *testing.T) {
+	for _, c := range [][]string{
+		{"git", "push", "--force"},
+		{"git", "push", "origin", "+main"},
+		{"git", "push", "--force-with-lease=main"},
+		{"git", "reset", "--hard", "origin/main"},
+		{"git", "branch", "-D", "feature"},
+		{"git", "branch", "--delete", "--force", "feature"},
+		{"git", "branch", "-df", "feature"},
+		{"git", "clean", "-fdx"},
+		{"git", "-c", "core.x=y", "push", "--force"},
+		{"git", "-C", "repo", "reset", "--hard"},
+		{"/bin/sh", "-c", "git fetch && git reset --hard @{u}", "sh"},
+	} {
+		if !isDestructive(c) {
+			t.Errorf("isDestructive(%q) = false, want true", c)
+		}
+	}
+	for _, c := range [][]string{
+		{"git", "push"},
+		{"git", "reset", "--soft", "HEAD~1"},
+		{"git", "branch", "-d", "feature"},
+		{"git", "branch", "--force", "feature", "main"},
+		{"git", "clean", "-n"},
+		{"git", "-C", "--force", "status"},
+		{"/bin/sh", "-c", "git push && rm -f out.log", "sh"},
+	} {
+		if isDestructive(c) {
+			t.Errorf("isDestructive(%q) = true, want false", c)
+		}
+	}
 }
 
 func TestNeedsShell(t *testing.T) {
@@ -381,9 +587,311 @@ func TestListRepositories(t *testing.T) {
 	assertEqual(t, result["others"][1], "/Users/jcgay/dev/buildplan-maven-plugin")
 }
 
+func TestRepositorySettings(t *testing.T) {
+	file := t.TempDir() + "/.parallel-git-repositories"
+	os.WriteFile(file, []byte(`
+[repositories]
+  default = ["/dev/app", { path = "/dev/release", protected_commands = ["merge"], labels = ["java"] }]
+  vendor = ["/dev/mirror", "/dev/release"]
+
+[groups.vendor]
+  readonly = true
+  protected_commands = ["push"]
+`), 0644)
+	config := newConfiguration(file)
+
+	assertEqual(t, strings.Join(config.ListRepositories()["default"], ","), "/dev/app,/dev/release")
+
+	if got := config.Settings("/dev/app"); got.Readonly || len(got.ProtectedCommands) != 0 {
+		t.Errorf("got %+v for an unprotected repository", got)
+	}
+	release := config.Settings("/dev/release")
+	if !release.Readonly {
+		t.Error("a repository of a read-only group should be read-only")
+	}
+	assertEqual(t, strings.Join(sortedKeys(toSet(release.ProtectedCommands)), ","), "merge,push")
+	assertEqual(t, strings.Join(release.Labels, ","), "java")
+}
+
+// settingsRepositories adds per-repository settings to a fixed group map.
+type settingsRepositories struct {
+	staticRepositories
+	settings map[string]settings
+}
+
+func (repos settingsRepositories) Settings(path string) settings {
+	return repos.settings[path]
+}
+
+func TestRunSkipsProtectedRepositories(t *testing.T) {
+	open, mirror, release := t.TempDir(), t.TempDir(), t.TempDir()
+	repos := settingsRepositories{
+		staticRepositories{"default": {open, mirror, release}},
+		map[string]settings{
+			mirror:  {Readonly: true},
+			release: {ProtectedCommands: []string{"push"}},
+		},
+	}
+	run := func(names []string, writes bool) string {
+		runner, output := testRunner(&PrintArgumentsCommand{}, repos)
+		runner.commandNames = names
+		runner.writes = writes
+		if failures := runner.Run([]string{"ran"}, "default"); failures != 0 {
+			t.Errorf("got %d failures, skipped repositories are not failures", failures)
+		}
+		return output.String()
+	}
+
+	out := run([]string{"status", "status"}, false)
+	if strings.Contains(out, "skipped") || strings.Count(out, "ran") != 3 {
+		t.Errorf("a read command should run everywhere, got %q", out)
+	}
+
+	out = run([]string{"push", "push"}, true)
+	if !strings.Contains(out, filepath.Base(mirror)+": skipped (protected)") || !strings.Contains(out, filepath.Base(release)+": skipped (protected)") {
+		t.Errorf("expected mirror and release to be skipped, got %q", out)
+	}
+	if strings.Count(out, "ran") != 1 {
+		t.Errorf("expected the command to run in one repository, got %q", out)
+	}
+}
+
+func TestReadonlyRepositoriesRefuseDeletingABranch(t *testing.T) {
+	repo := gitRepository(t, "mirror", map[string]string{"README.md": "mirror"})
+	git(t, repo, "branch", "feature")
+	toExec := []string{"git", "branch", "-D", "feature"}
+	runner, output := testRunner(&run{ToExec: toExec}, settingsRepositories{
+		staticRepositories{"default": {repo}},
+		map[string]settings{repo: {Readonly: true}},
+	})
+	for _, invocation := range gitInvocations(toExec) {
+		runner.writes = runner.writes || writesRepository(invocation)
+	}
+
+	runner.Run(nil, "default")
+
+	assertEqual(t, output.String(), "mirror: skipped (protected)\n")
+	assertEqual(t, git(t, repo, "branch", "--list", "feature"), "feature")
+}
+
+func TestReadonlyRepositoriesRefuseWritesBehindGitOptions(t *testing.T) {
+	repo := gitRepository(t, "mirror", map[string]string{"README.md": "mirror"})
+	run := func(command string) string {
+		toExec := strings.Fields(command)
+		runner, output := testRunner(&run{ToExec: toExec}, settingsRepositories{
+			staticRepositories{"default": {repo}},
+			map[string]settings{repo: {Readonly: true}},
+		})
+		for _, invocation := range gitInvocations(toExec) {
+			runner.writes = runner.writes || writesRepository(invocation)
+		}
+		runner.Run(nil, "default")
+		return output.String()
+	}
+
+	for _, command := range []string{"git -C . tag bypass", "git -c user.name=x commit --allow-empty -m bypass", "git remote add x /nowhere"} {
+		assertEqual(t, run(command), "mirror: skipped (protected)\n")
+	}
+	assertEqual(t, run("git stash list"), "mirror: "+ok+"\n")
+	assertEqual(t, git(t, repo, "tag"), "")
+	assertEqual(t, git(t, repo, "remote"), "")
+}
+
+func TestWritingGitCommandsAreToldFromReadingOnes(t *testing.T) {
+	for command, writes := range map[string]bool{
+		"git branch":                              false,
+		"git branch -r --merged":                  false,
+		"git branch --list $1 -r":                 false,
+		"git branch -r --contains v1":             false,
+		"git branch feature":                      true,
+		"git branch -D feature":                   true,
+		"git branch -dr origin/feature":           true,
+		"git branch -u origin/main":               true,
+		"git tag":                                 false,
+		"git tag -l v1.*":                         false,
+		"git tag --points-at HEAD":                false,
+		"git tag v1.0":                            true,
+		"git tag -a v1.0 -m release":              true,
+		"git tag -d v1.0":                         true,
+		"git worktree list --porcelain":           false,
+		"git worktree add ../hotfix":              true,
+		"git submodule":                           false,
+		"git submodule --quiet foreach ls":        false,
+		"git submodule update --init":             true,
+		"git notes show":                          false,
+		"git notes add -m reviewed":               true,
+		"git update-ref refs/heads/x HEAD":        true,
+		"git gc":                                  true,
+		"git log":                                 false,
+		"git stash":                               true,
+		"git stash list":                          false,
+		"git stash show -p stash@{0}":             false,
+		"git stash pop":                           true,
+		"git remote -v":                           false,
+		"git remote get-url origin":               false,
+		"git remote add x /nowhere":               true,
+		"git remote set-url origin /x":            true,
+		"git config user.name":                    false,
+		"git config --get-regexp ^remote":         false,
+		"git config --file .gitmodules -l":        false,
+		"git config get user.name":                false,
+		"git config user.name me":                 true,
+		"git config set user.name me":             true,
+		"git config --unset user.name":            true,
+		"git -C . tag bypass":                     true,
+		"git -c k=v commit":                       true,
+		"git --git-dir=.git --work-tree=. commit": true,
+		"git --git-dir .git tag -l":               false,
+		"git --no-pager log":                      false,
+	} {
+		if got := writesRepository(gitInvocations(strings.Fields(command))[0]); got != writes {
+			t.Errorf("%s: got writes=%v, want %v", command, got, writes)
+		}
+	}
+}
+
+func TestConfirmationLeavesOutProtectedRepositories(t *testing.T) {
+	repos := settingsRepositories{
+		staticRepositories{"default": {"/dev/app", "/dev/mirror", "/dev/release"}},
+		map[string]settings{
+			"/dev/mirror":  {Readonly: true},
+			"/dev/release": {ProtectedCommands: []string{"push"}},
+		},
+	}
+	runner, _ := testRunner(nil, repos)
+	runner.commandNames = []string{"push", "push"}
+	runner.writes = true
+
+	affected, err := runner.affected("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, strings.Join(affected, ","), "/dev/app")
+}
+
+func TestEnvironmentIsMergedFromGlobalGroupAndRepository(t *testing.T) {
+	file := t.TempDir() + "/.parallel-git-repositories"
+	os.WriteFile(file, []byte(`
+[env]
+  MAVEN_OPTS = "-Xmx1g"
+  JAVA_HOME = "/jdk/17"
+
+[repositories]
+  default = ["/dev/app", { path = "/dev/legacy", env = { JAVA_HOME = "/jdk/8" } }]
+  java21 = ["/dev/app", "/dev/legacy"]
+
+[groups.java21.env]
+  JAVA_HOME = "/jdk/21"
+`), 0644)
+	config := newConfiguration(file)
+
+	app := config.Settings("/dev/app").Env
+	assertEqual(t, app["MAVEN_OPTS"], "-Xmx1g")
+	assertEqual(t, app["JAVA_HOME"], "/jdk/21")
+	assertEqual(t, config.Settings("/dev/legacy").Env["JAVA_HOME"], "/jdk/8")
+}
+
+func TestRunPassesConfiguredEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	repos := settingsRepositories{
+		staticRepositories{"default": {dir}},
+		map[string]settings{dir: {Env: map[string]string{"GREETING": "hello from env"}}},
+	}
+	runner, output := testRunner(&run{ToExec: []string{"/bin/sh", "-c", "echo $GREETING"}}, repos)
+
+	runner.Run(nil, "default")
+
+	assertEqual(t, output.String(), filepath.Base(dir)+": "+ok+"\n  hello from env\n")
+}
+
+func TestRunReportsEachRepositoryAsItCompletes(t *testing.T) {
+	slow, fast := filepath.Join(t.TempDir(), "slow"), filepath.Join(t.TempDir(), "fast")
+	os.Mkdir(slow, 0755)
+	os.Mkdir(fast, 0755)
+	os.WriteFile(filepath.Join(slow, "slow"), nil, 0644)
+	runner, output := testRunner(&run{ToExec: []string{"/bin/sh", "-c", "[ -e slow ] && sleep 0.2; echo one; echo two >&2; echo three"}}, staticRepositories{"default": {slow, fast}})
+
+	runner.Run(nil, "default")
+
+	assertEqual(t, output.String(), "fast: "+ok+"\n  one\ntwo\nthree\nslow: "+ok+"\n  one\ntwo\nthree\n")
+}
+
+func TestRunUsesWorkdirForNonGitCommands(t *testing.T) {
+	repo := gitRepository(t, "monorepo", map[string]string{"backend/pom.xml": "<project/>"})
+	repos := settingsRepositories{
+		staticRepositories{"default": {repo}},
+		map[string]settings{repo: {Workdir: "backend"}},
+	}
+	run := func(toExec ...string) string {
+		runner, output := testRunner(&run{ToExec: toExec}, repos)
+		runner.Run(nil, "default")
+		return output.String()
+	}
+
+	if out := run("ls"); !strings.Contains(out, "pom.xml") {
+		t.Errorf("non-git command should run in the workdir, got %q", out)
+	}
+	if out := run("git", "rev-parse", "--show-prefix"); strings.Contains(out, "backend") {
+		t.Errorf("git command should run at the repository root, got %q", out)
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := []string{"java", "service", "team-a"}
+	for expr, want := range map[string]bool{
+		"java":              true,
+		"go":                false,
+		"java,service":      true,
+		"java,!service":     false,
+		"!legacy":           true,
+		"go|java,!legacy":   true,
+		"go|kotlin,service": false,
+	} {
+		if got := matchLabels(expr, labels); got != want {
+			t.Errorf("matchLabels(%q) = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestLabelsNarrowTheSelectionAndList(t *testing.T) {
+	repos := settingsRepositories{
+		staticRepositories{"default": {"/api", "/web"}, "old": {"/legacy"}},
+		map[string]settings{
+			"/api":    {Labels: []string{"java", "service"}},
+			"/legacy": {Labels: []string{"java", "legacy"}},
+		},
+	}
+	runner, output := testRunner(nil, repos)
+	runner.labels = "java,!legacy"
+
+	selected, err := runner.selection("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(selected, ","), "/api")
+
+	if err := runner.List("default"); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, output.String(), "default:\n  - /api\n")
+}
+
+func TestGitInvocations(t *testing.T) {
+	got := gitInvocations([]string{"/bin/sh", "-c", "git fetch -p && git merge --ff-only", "sh"})
+	if len(got) != 2 || got[0][0] != "fetch" || got[1][0] != "merge" {
+		t.Errorf("got %q, want the fetch and merge invocations", got)
+	}
+
+	got = gitInvocations([]string{"git", "-C", "sub", "-c", "k=v", "--no-pager", "--git-dir=.git", "log", "-1"})
+	if len(got) != 1 || strings.Join(got[0], " ") != "log -1" {
+		t.Errorf("got %q, want the log invocation without git's own options", got)
+	}
+}
+
 func TestAddRegistersRepository(t *testing.T) {
 	file := t.TempDir() + "/.parallel-git-repositories"
-	os.WriteFile(file, []byte("[repositories]\n  default = [\"/existing\"]\n[commands]\n  pull = \"git pull\"\n"), 0644)
+	os.WriteFile(file, []byte("[repositories]\n  default = [\"/existing\"]\n  work = [{ path = \"/mirror\", readonly = true }]\n[commands]\n  pull = \"git pull\"\n"), 0644)
 
 	repo := t.TempDir()
 	os.Mkdir(filepath.Join(repo, ".git"), 0755)
@@ -393,8 +901,11 @@ func TestAddRegistersRepository(t *testing.T) {
 	}
 
 	config := newConfiguration(file)
-	if got := config.ListRepositories()["work"]; len(got) != 1 || got[0] != repo {
-		t.Errorf("got %v, want [%s]", got, repo)
+	if got := config.ListRepositories()["work"]; len(got) != 2 || got[1] != repo {
+		t.Errorf("got %v, want [/mirror %s]", got, repo)
+	}
+	if !config.Settings("/mirror").Readonly {
+		t.Error("attributes of existing entries were lost")
 	}
 	// The rest of the file is preserved, not rewritten from scratch.
 	if len(config.ListRepositories()["default"]) != 1 {
@@ -416,11 +927,13 @@ func TestAddRegistersRepository(t *testing.T) {
 func TestConfigFilePrefersFlagThenEnvThenDefault(t *testing.T) {
 	savedHome, savedFlag := home, configFlag
 	defer func() { home, configFlag = savedHome, savedFlag }()
+	t.Chdir(t.TempDir())
 
-	home = "/home/user"
+	home = t.TempDir()
 	configFlag = ""
-	os.Unsetenv("PARALLEL_GIT_REPO_CONFIG")
-	assertEqual(t, configFile(), "/home/user/.parallel-git-repositories")
+	t.Setenv("PARALLEL_GIT_REPO_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assertEqual(t, configFile(), filepath.Join(home, ".parallel-git-repositories"))
 
 	t.Setenv("PARALLEL_GIT_REPO_CONFIG", "/env.toml")
 	assertEqual(t, configFile(), "/env.toml")
@@ -429,6 +942,47 @@ func TestConfigFilePrefersFlagThenEnvThenDefault(t *testing.T) {
 	assertEqual(t, configFile(), "/flag.toml")
 }
 
+func TestHelpAlignsBuiltinAndConfiguredCommands(t *testing.T) {
+	saved := configFlag
+	defer func() { configFlag = saved }()
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(configFlag, []byte("[commands]\n  pull = \"git pull\"\n"), 0644)
+
+	width := len("  prune-branches\t")
+	for _, line := range strings.Split(strings.TrimSuffix(listCommands(), "\n"), "\n") {
+		if tab := strings.Index(line, "\t"); tab+1 != width {
+			t.Errorf("description of %q starts at column %d, want %d", line, tab+1, width)
+		}
+	}
+}
+
+func TestConfiguredCommandsTakePrecedenceOverNewerBuiltins(t *testing.T) {
+	saved := configFlag
+	defer func() { configFlag = saved }()
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(configFlag, []byte(`
+[commands]
+  sync = "git fetch -p && git pull"
+  list = "ls"
+`), 0644)
+	config := newConfiguration(configFlag)
+
+	if !shadowed(config, "sync") {
+		t.Error("expected the configured sync to replace the built-in one")
+	}
+	if shadowed(config, "list") || shadowed(config, "log") || shadowed(config, "pull") {
+		t.Error("expected only configured commands named like a newer built-in to be shadowed")
+	}
+
+	help := listCommands()
+	if strings.Count(help, "  sync ") != 1 || !strings.Contains(help, "git fetch -p && git pull") {
+		t.Errorf("expected sync once, with its configured description:\n%s", help)
+	}
+	if strings.Count(help, "  list ") != 1 || strings.Contains(help, "  ls\n") {
+		t.Errorf("expected only the built-in list:\n%s", help)
+	}
+}
+
 func TestListCommands(t *testing.T) {
 	dir := t.TempDir()
 	config := `
@@ -439,6 +993,12 @@ func TestListCommands(t *testing.T) {
 [commands]
   pull = "git pull"
   current-branch = "git symbolic-ref --short HEAD"
+  [commands.dirty]
+    command = "git diff --quiet"
+    changed_codes = [1]
+  [commands.publish]
+    command = "git push"
+    confirm = true
 `
 	file := dir + "/.parallel-git-repositories"
 	os.WriteFile(file, []byte(config), 0644)
@@ -446,9 +1006,18 @@ func TestListCommands(t *testing.T) {
 
 	result := commands.ListCommands()
 
-	if len(result) != 2 {
-		t.Fatalf("expected 2 commands, got %d", len(result))
+	if len(result) != 4 {
+		t.Fatalf("expected 4 commands, got %d", len(result))
 	}
 	assertEqual(t, result["pull"], "git pull")
 	assertEqual(t, result["current-branch"], "git symbolic-ref --short HEAD")
+	assertEqual(t, result["dirty"], "git diff --quiet")
+
+	spec, _ := commands.Command("dirty")
+	if len(spec.ChangedCodes) != 1 || spec.ChangedCodes[0] != 1 {
+		t.Errorf("got changed codes %v, want [1]", spec.ChangedCodes)
+	}
+	if spec, _ := commands.Command("publish"); !spec.Confirm {
+		t.Error("expected publish to ask for confirmation")
+	}
 }
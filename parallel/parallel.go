@@ -0,0 +1,197 @@
+// Package parallel runs a command in many Git repositories at once, with
+// bounded parallelism and a timeout per repository. It is the engine behind
+// the parallel-git-repo command line, for Go programs that would otherwise
+// shell out to it and parse its ✔/✘ output:
+//
+//	runner := &parallel.Runner{Jobs: 8, Timeout: time.Minute}
+//	repos, _ := parallel.SelectRepositories(groups, "backend,-legacy")
+//	for _, result := range runner.Run(ctx, repos, parallel.CommandLine{"git", "fetch", "$@"}, "--prune") {
+//		if result.Err != nil {
+//			log.Printf("%s: %v\n%s", result.Repository, result.Err, result.Stderr)
+//		}
+//	}
+package parallel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Command is run in every repository. Its options may hold the $@ and $1,
+// $2… placeholders filled by ForwardArgs.
+type Command interface {
+	Executable() string
+	Options() []string
+}
+
+// CommandLine is a Command written as its executable followed by its options.
+type CommandLine []string
+
+func (command CommandLine) Executable() string {
+	return command[0]
+}
+
+func (command CommandLine) Options() []string {
+	return command[1:]
+}
+
+// Result is the outcome of a command in one repository.
+type Result struct {
+	Repository string
+	Stdout     string
+	Stderr     string
+	// ExitCode is the command's exit status, -1 when it did not start or was
+	// killed.
+	ExitCode int
+	// Err is nil when the command exited with 0. Otherwise it is an
+	// *exec.ExitError, a *TimeoutError, the context's error when it was
+	// cancelled, or the reason the command could not start.
+	Err      error
+	Duration time.Duration
+}
+
+// TimeoutError reports a command killed for running longer than the runner's
+// Timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", err.Timeout)
+}
+
+func (err *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Runner runs commands across repositories.
+type Runner struct {
+	// Jobs is the maximum number of repositories processed at once; below 1
+	// they are processed one at a time.
+	Jobs int
+	// Timeout bounds the time spent in each repository, 0 meaning no limit.
+	Timeout time.Duration
+	// Prepare, when set, adjusts each command before it starts, e.g. its
+	// environment, directory or standard input. Commands run from the
+	// repository with the process environment and GIT_TERMINAL_PROMPT=0, so
+	// git fails fast instead of waiting on a credential prompt.
+	Prepare func(command *exec.Cmd, repo string)
+	// Expand, when set, rewrites the arguments of the command for each
+	// repository, after ForwardArgs, e.g. to fill placeholders naming the
+	// repository. It shares the repository's Timeout; an error fails the
+	// repository without running the command.
+	Expand func(ctx context.Context, repo string, argv []string) ([]string, error)
+	// Combined sends the command's stderr to Result.Stdout, interleaved with
+	// its stdout in the order they were written, leaving Result.Stderr empty.
+	Combined bool
+	// OnResult, when set, is called with each result as soon as its command
+	// ends, so callers can report progress before Run returns. Calls are
+	// serialised.
+	OnResult func(result Result)
+}
+
+// Run runs command with its placeholders filled from args in every
+// repository and returns the results in the order of repos. Cancelling ctx
+// kills the commands still running and fails those not started yet.
+func (runner *Runner) Run(ctx context.Context, repos []string, command Command, args ...string) []Result {
+	argv := ForwardArgs(command.Options(), args)
+	results := make([]Result, len(repos))
+	var mu sync.Mutex
+	runner.Each(ctx, repos, func(ctx context.Context, i int, repo string) {
+		results[i] = runner.run(ctx, repo, command.Executable(), argv)
+		if runner.OnResult != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			runner.OnResult(results[i])
+		}
+	})
+	return results
+}
+
+func (runner *Runner) run(ctx context.Context, repo, executable string, argv []string) Result {
+	start := time.Now()
+	if runner.Expand != nil {
+		var err error
+		if argv, err = runner.Expand(ctx, repo, argv); err != nil {
+			return Result{Repository: repo, ExitCode: -1, Err: err, Duration: time.Since(start)}
+		}
+	}
+	cmd := exec.CommandContext(ctx, executable, argv...)
+	cmd.Dir = repo
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if runner.Combined {
+		cmd.Stderr = &stdout
+	}
+	if runner.Prepare != nil {
+		runner.Prepare(cmd, repo)
+	}
+
+	err := runner.Execute(ctx, cmd)
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	return Result{
+		Repository: repo,
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		ExitCode:   exitCode,
+		Err:        err,
+		Duration:   time.Since(start),
+	}
+}
+
+// Each calls fn for every repository, with its index in repos, and waits for
+// them all. Calls run in parallel, at most Jobs at a time: without a limit, a
+// large group spawns one git process per repository at once, thrashing disk
+// and tripping server-side limits on concurrent SSH connections. The context,
+// derived from ctx, carries Timeout, started when fn is actually scheduled
+// rather than when it was queued, so repositories waiting for a slot don't
+// burn their budget.
+func (runner *Runner) Each(ctx context.Context, repos []string, fn func(ctx context.Context, i int, repo string)) {
+	limit := runner.Jobs
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	wg := sync.WaitGroup{}
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx := ctx
+			if runner.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, runner.Timeout)
+				defer cancel()
+			}
+			fn(ctx, i, repo)
+		}(i, repo)
+	}
+	wg.Wait()
+}
+
+// Execute runs command, started with ctx, and reports a timeout as such
+// rather than as the "signal: killed" the child dies with.
+func (runner *Runner) Execute(ctx context.Context, command *exec.Cmd) error {
+	err := command.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded && runner.Timeout > 0:
+		return &TimeoutError{Timeout: runner.Timeout}
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRunReturnsOneResultPerRepository(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	runner := &Runner{Jobs: 2}
+
+	results := runner.Run(t.Context(), []string{first, second}, CommandLine{"sh", "-c", `pwd; echo $1 >&2; test "$(pwd)" = $2`}, "warning", first)
+
+	assertEqual(t, results[0].Repository, first)
+	assertEqual(t, results[0].Stdout, first+"\n")
+	assertEqual(t, results[0].Stderr, "warning\n")
+	assertEqual(t, strconv.Itoa(results[0].ExitCode), "0")
+	if results[0].Err != nil {
+		t.Errorf("expected no error, got %v", results[0].Err)
+	}
+
+	assertEqual(t, results[1].Repository, second)
+	assertEqual(t, strconv.Itoa(results[1].ExitCode), "1")
+	var exit *exec.ExitError
+	if !errors.As(results[1].Err, &exit) {
+		t.Errorf("expected an *exec.ExitError, got %v", results[1].Err)
+	}
+}
+
+func TestRunReportsTimeouts(t *testing.T) {
+	runner := &Runner{Jobs: 1, Timeout: 50 * time.Millisecond}
+
+	results := runner.Run(t.Context(), []string{t.TempDir()}, CommandLine{"sleep", "5"})
+
+	var timeout *TimeoutError
+	if !errors.As(results[0].Err, &timeout) || !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", results[0].Err)
+	}
+	assertEqual(t, results[0].Err.Error(), "timed out after 50ms")
+	assertEqual(t, strconv.Itoa(results[0].ExitCode), "-1")
+}
+
+func TestRunStopsWhenTheContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	results := (&Runner{}).Run(ctx, []string{t.TempDir()}, CommandLine{"true"})
+
+	if !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("expected the context's error, got %v", results[0].Err)
+	}
+}
+
+func TestRunLetsCallersPrepareCommands(t *testing.T) {
+	runner := &Runner{Prepare: func(command *exec.Cmd, repo string) {
+		command.Env = append(command.Env, "GREETING=hello from "+repo)
+	}}
+
+	results := runner.Run(t.Context(), []string{"/"}, CommandLine{"sh", "-c", `echo "$GREETING"`})
+
+	assertEqual(t, results[0].Stdout, "hello from /\n")
+}
+
+func TestRunExpandsArgumentsPerRepository(t *testing.T) {
+	ok, broken := t.TempDir(), t.TempDir()
+	runner := &Runner{Expand: func(ctx context.Context, repo string, argv []string) ([]string, error) {
+		if repo == broken {
+			return nil, errors.New("no default branch")
+		}
+		return append(argv, "in "+repo), nil
+	}}
+
+	results := runner.Run(t.Context(), []string{ok, broken}, CommandLine{"echo", "$@"}, "hello")
+
+	assertEqual(t, results[0].Stdout, "hello in "+ok+"\n")
+	assertEqual(t, results[1].Err.Error(), "no default branch")
+	assertEqual(t, strconv.Itoa(results[1].ExitCode), "-1")
+}
+
+func TestRunCombinesOutputInTheOrderWritten(t *testing.T) {
+	runner := &Runner{Combined: true}
+
+	results := runner.Run(t.Context(), []string{t.TempDir()}, CommandLine{"sh", "-c", "echo one; echo two >&2; echo three"})
+
+	assertEqual(t, results[0].Stdout, "one\ntwo\nthree\n")
+	assertEqual(t, results[0].Stderr, "")
+}
+
+func TestRunReportsEachResultAsItCompletes(t *testing.T) {
+	slow, fast := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(slow, "slow"), nil, 0644)
+	var reported []string
+	runner := &Runner{Jobs: 2, OnResult: func(result Result) {
+		reported = append(reported, result.Repository)
+	}}
+
+	results := runner.Run(t.Context(), []string{slow, fast}, CommandLine{"sh", "-c", "[ -e slow ] && sleep 0.2; true"})
+
+	assertEqual(t, reported[0]+","+reported[1], fast+","+slow)
+	assertEqual(t, results[0].Repository, slow)
+}
@@ -0,0 +1,145 @@
+package parallel
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Repositories lists repository paths by group name.
+type Repositories interface {
+	ListRepositories() map[string][]string
+}
+
+// SelectRepositories resolves a group specifier into the deduplicated list of
+// repositories to run against. The specifier is a comma-separated list of
+// terms whose repositories are unioned: a group name, the special value "all"
+// for every group, or groups joined with & to keep only the repositories they
+// share ("backend&java"). A term prefixed with - removes its repositories
+// instead ("all,-legacy"); a specifier made only of such terms starts from
+// every group. A repository listed in more than one selected group is kept
+// once, in first-seen order. An unknown group name is an error.
+func SelectRepositories(all map[string][]string, group string) ([]string, error) {
+	included, excluded := splitGroupSpecifier(group)
+
+	removed := make(map[string]struct{})
+	for _, term := range excluded {
+		members, err := groupTerm(all, term)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range members {
+			removed[repo] = struct{}{}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var repos []string
+	for _, term := range included {
+		members, err := groupTerm(all, term)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range members {
+			if _, dup := seen[repo]; dup {
+				continue
+			}
+			seen[repo] = struct{}{}
+			if _, out := removed[repo]; !out {
+				repos = append(repos, repo)
+			}
+		}
+	}
+	return repos, nil
+}
+
+// SelectedGroups returns the names of the groups a specifier selects
+// repositories from, "all" expanded to every group in name order. The groups
+// it only removes repositories of are left out.
+func SelectedGroups(all map[string][]string, group string) []string {
+	var names []string
+	included, _ := splitGroupSpecifier(group)
+	for _, term := range included {
+		for _, name := range strings.Split(term, "&") {
+			if name == "all" {
+				names = append(names, slices.Sorted(maps.Keys(all))...)
+			} else {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// splitGroupSpecifier separates the terms of a group specifier selecting
+// repositories from the ones, prefixed with -, removing them.
+func splitGroupSpecifier(group string) (included, excluded []string) {
+	for _, term := range strings.Split(group, ",") {
+		if name, found := strings.CutPrefix(term, "-"); found {
+			excluded = append(excluded, name)
+		} else {
+			included = append(included, term)
+		}
+	}
+	if len(included) == 0 {
+		included = []string{"all"}
+	}
+	return included, excluded
+}
+
+// groupTerm resolves one term of a group specifier: groups joined with &,
+// intersected in the order of the first one.
+func groupTerm(all map[string][]string, term string) ([]string, error) {
+	var result []string
+	for i, name := range strings.Split(term, "&") {
+		var members []string
+		if name == "all" {
+			for _, key := range slices.Sorted(maps.Keys(all)) {
+				members = append(members, all[key]...)
+			}
+		} else {
+			var found bool
+			if members, found = all[name]; !found {
+				return nil, fmt.Errorf("Unknown group %q, available groups: %s", name, strings.Join(slices.Sorted(maps.Keys(all)), ", "))
+			}
+		}
+		if i == 0 {
+			result = members
+			continue
+		}
+		result = slices.DeleteFunc(slices.Clone(result), func(repo string) bool {
+			return !slices.Contains(members, repo)
+		})
+	}
+	return result, nil
+}
+
+var option = regexp.MustCompile(`\$([0-9]+)`)
+
+// ForwardArgs fills the placeholders of a command's options with the
+// arguments given on the command line: $@ expands to all of them and $1, $2…
+// to one each. A placeholder without a matching argument is left untouched.
+func ForwardArgs(opts []string, args []string) []string {
+	result := make([]string, 0)
+	for _, opt := range opts {
+		if opt == "$@" {
+			result = append(result, args...)
+		} else if option.MatchString(opt) {
+			result = append(result, option.ReplaceAllStringFunc(opt, func(substring string) string {
+				index, _ := strconv.Atoi(substring[1:])
+				// Leave the placeholder untouched when no matching argument was
+				// provided, rather than panicking on an out-of-range index.
+				if index < 1 || index > len(args) {
+					return substring
+				}
+				return args[index-1]
+			}))
+		} else {
+			result = append(result, opt)
+		}
+	}
+	return result
+}
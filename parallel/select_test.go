@@ -0,0 +1,83 @@
+package parallel
+
+import (
+	"strings"
+	"testing"
+)
+
+func assertEqual(t *testing.T, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSelectRepositories(t *testing.T) {
+	all := map[string][]string{
+		"default":  {"/a", "/b"},
+		"notifier": {"/b", "/c"},
+		"maven":    {"/d"},
+	}
+
+	if _, err := SelectRepositories(all, "nope"); err == nil {
+		t.Error("expected an error for an unknown group")
+	}
+
+	comma, err := SelectRepositories(all, "notifier,maven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(comma, ","), "/b,/c,/d")
+
+	// A repo shared by two selected groups is kept once.
+	shared, err := SelectRepositories(all, "default,notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(shared, ","), "/a,/b,/c")
+
+	// "all" fans out over every group, deterministically and deduplicated.
+	every, err := SelectRepositories(all, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(every, ","), "/a,/b,/d,/c")
+
+	// Terms prefixed with - remove their repositories.
+	except, err := SelectRepositories(all, "all,-notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(except, ","), "/a,/d")
+
+	only, err := SelectRepositories(all, "-maven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(only, ","), "/a,/b,/c")
+
+	// & keeps the repositories shared by the groups.
+	both, err := SelectRepositories(all, "default&notifier,maven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Join(both, ","), "/b,/d")
+
+	if _, err := SelectRepositories(all, "all,-nope"); err == nil {
+		t.Error("expected an error for an unknown excluded group")
+	}
+}
+
+func TestSelectedGroups(t *testing.T) {
+	all := map[string][]string{"default": {"/a"}, "notifier": {"/b"}, "maven": {"/c"}}
+
+	assertEqual(t, strings.Join(SelectedGroups(all, "all,-maven"), ","), "default,maven,notifier")
+	assertEqual(t, strings.Join(SelectedGroups(all, "default&notifier,maven"), ","), "default,notifier,maven")
+}
+
+func TestForwardArgsLeavesPlaceholderWhenArgumentIsMissing(t *testing.T) {
+	result := ForwardArgs([]string{"$1", "$3"}, []string{"only-first"})
+
+	assertEqual(t, result[0], "only-first")
+	assertEqual(t, result[1], "$3")
+}
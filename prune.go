@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// staleBranch is a local branch prune-branches proposes to delete.
+type staleBranch struct {
+	Name string
+	// Merged is false for a branch kept only because its upstream is gone:
+	// squash or rebase merges leave such branches unmerged in Git's eyes.
+	Merged bool
+}
+
+// pruneCommand implements `prune-branches`: preview the local branches that
+// can go in every repository, then delete them once confirmed. A configured
+// `git branch --merged | grep -v master` hardcodes the default branch and
+// cannot be previewed.
+func pruneCommand(config *configuration, args []string, group string) int {
+	fs := flag.NewFlagSet("prune-branches", flag.ContinueOnError)
+	gone := fs.Bool("gone", false, "run git fetch -p first and also prune branches whose upstream is gone")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	runner := configuredRunner(nil, config)
+	runner.commandNames = []string{"prune-branches"}
+	runner.writes = true
+	repos, err := runner.selected(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	stale, failures := runner.StaleBranches(repos, *gone)
+	count, within := 0, 0
+	for _, branches := range stale {
+		count += len(branches)
+		if len(branches) > 0 {
+			within++
+		}
+	}
+	if count == 0 {
+		fmt.Fprintln(runner.writer, "Nothing to prune.")
+		return failures
+	}
+	if !assumeYes && !confirm(fmt.Sprintf("Delete %d %s in %d %s?", count, plural(count, "branch", "branches"), within, plural(within, "repository", "repositories"))) {
+		return failures
+	}
+	return failures + runner.Prune(stale)
+}
+
+// StaleBranches finds, in every repository, the local branches fully merged
+// into its default branch and, with gone, those whose upstream was deleted
+// (after a `git fetch -p`). It prints them as one preview and returns them by
+// repository, along with the number of repositories that could not be
+// inspected.
+func (runner *runner) StaleBranches(repos []string, gone bool) (map[string][]staleBranch, int) {
+	found := make([][]staleBranch, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		found[i], errs[i] = runner.staleBranches(ctx, repo, gone)
+	})
+
+	failures := 0
+	stale := make(map[string][]staleBranch)
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		if len(found[i]) == 0 {
+			continue
+		}
+		stale[repo] = found[i]
+		fmt.Fprintf(runner.writer, "%s:\n", filepath.Base(repo))
+		for _, branch := range found[i] {
+			reason := "merged"
+			if !branch.Merged {
+				reason = "upstream gone"
+			}
+			fmt.Fprintf(runner.writer, "  - %s (%s)\n", branch.Name, reason)
+		}
+	}
+	return stale, failures
+}
+
+func (runner *runner) staleBranches(ctx context.Context, repo string, gone bool) ([]staleBranch, error) {
+	if gone {
+		if _, err := runner.git(ctx, repo, "fetch", "--prune", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+	base, err := runner.defaultBranch(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	// Compare against the remote-tracking branch when there is one: the local
+	// default branch may lag behind what was merged upstream.
+	target := base
+	if runner.hasRef(ctx, repo, "refs/remotes/origin/"+base) {
+		target = "origin/" + base
+	}
+	current, _ := runner.git(ctx, repo, "symbolic-ref", "--short", "--quiet", "HEAD")
+
+	out, err := runner.git(ctx, repo, "for-each-ref", "--format=%(refname:short)%00%(upstream:track)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	merged, err := runner.git(ctx, repo, "for-each-ref", "--format=%(refname:short)", "--merged", target, "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	isMerged := make(map[string]bool)
+	for _, name := range strings.Split(merged, "\n") {
+		isMerged[name] = true
+	}
+
+	var stale []staleBranch
+	for _, line := range strings.Split(out, "\n") {
+		name, track, _ := strings.Cut(line, "\x00")
+		if name == "" || name == base || name == current {
+			continue
+		}
+		if isMerged[name] || (gone && track == "[gone]") {
+			stale = append(stale, staleBranch{Name: name, Merged: isMerged[name]})
+		}
+	}
+	return stale, nil
+}
+
+// Prune deletes the given branches, repository by repository, and returns the
+// number of repositories where a deletion failed.
+func (runner *runner) Prune(stale map[string][]staleBranch) int {
+	repos := sortedKeys(stale)
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		// --force: StaleBranches already checked them against the default
+		// branch, whereas plain -d checks against whatever HEAD is.
+		args := []string{"branch", "--delete", "--force"}
+		for _, branch := range stale[repo] {
+			args = append(args, branch.Name)
+		}
+		_, errs[i] = runner.git(ctx, repo, args...)
+	})
+
+	failures := 0
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		fmt.Fprintf(runner.writer, "%s: %s\n", filepath.Base(repo), ok)
+	}
+	return failures
+}
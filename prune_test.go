@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneBranchesMergedIntoDefaultBranchAndGoneUpstreams(t *testing.T) {
+	origin := gitRepository(t, "origin", nil)
+	repo := filepath.Join(t.TempDir(), "maven-color")
+	git(t, origin, "clone", "-q", origin, repo)
+
+	git(t, repo, "branch", "done")
+	git(t, repo, "switch", "-q", "-c", "wip")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "work in progress")
+	git(t, repo, "switch", "-q", "-c", "squashed")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "squash merged upstream")
+	git(t, repo, "push", "-q", "-u", "origin", "squashed")
+	git(t, repo, "switch", "-q", "main")
+	git(t, origin, "branch", "-D", "squashed")
+
+	runner, output := testRunner(nil, staticRepositories{})
+
+	stale, failures := runner.StaleBranches([]string{repo}, false)
+	if failures != 0 {
+		t.Fatalf("got %d failures, want 0: %s", failures, output)
+	}
+	if got := stale[repo]; len(got) != 1 || got[0] != (staleBranch{"done", true}) {
+		t.Errorf("got %v, want only the merged branch", got)
+	}
+
+	output.Reset()
+	stale, _ = runner.StaleBranches([]string{repo}, true)
+	if got := stale[repo]; len(got) != 2 || got[1] != (staleBranch{"squashed", false}) {
+		t.Errorf("got %v, want the merged and the gone branch", got)
+	}
+	assertEqual(t, output.String(), "maven-color:\n  - done (merged)\n  - squashed (upstream gone)\n")
+
+	if failures := runner.Prune(stale); failures != 0 {
+		t.Errorf("got %d failures, want 0", failures)
+	}
+	assertEqual(t, git(t, repo, "for-each-ref", "--format=%(refname:short)", "refs/heads"), "main\nwip")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const snapshotUsage = `usage: parallel-git-repo snapshot save NAME
+       parallel-git-repo snapshot restore NAME [--force] [--partial]`
+
+// snapshot records where a set of repositories stood, to undo a bulk merge or
+// checkout that went wrong.
+type snapshot struct {
+	Name         string               `json:"name"`
+	Created      time.Time            `json:"created"`
+	Repositories []repositorySnapshot `json:"repositories"`
+}
+
+type repositorySnapshot struct {
+	Path string `json:"path"`
+	// Branch is empty when HEAD was detached.
+	Branch string `json:"branch,omitempty"`
+	Head   string `json:"head"`
+	// Stash is the commit recording local changes to tracked files, if any.
+	Stash string `json:"stash,omitempty"`
+}
+
+// snapshotFile returns where the snapshot name is stored:
+// $XDG_STATE_HOME/parallel-git-repo/snapshots, ~/.local/state when the
+// variable is unset.
+func snapshotFile(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("Invalid snapshot name %q", name)
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "parallel-git-repo", "snapshots", name+".json"), nil
+}
+
+// snapshotCommand implements `snapshot save` and `snapshot restore`.
+func snapshotCommand(config *configuration, args []string, group string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, snapshotUsage)
+		return 1
+	}
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ContinueOnError)
+	force := fs.Bool("force", false, "restore even over local changes, discarding them")
+	partial := fs.Bool("partial", false, "restore the repositories that pass the preflight checks even if others fail them")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return 1
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, snapshotUsage)
+		return 1
+	}
+	file, err := snapshotFile(positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	runner := configuredRunner(nil, config)
+	// Both write: save stores the local changes as a stash entry.
+	runner.commandNames = []string{"snapshot"}
+	runner.writes = true
+	switch args[0] {
+	case "save":
+		repos, err := runner.selected(group)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return runner.SaveSnapshot(positional[0], file, repos)
+	case "restore":
+		return runner.RestoreSnapshot(file, *force, *partial)
+	}
+	fmt.Fprintln(os.Stderr, snapshotUsage)
+	return 1
+}
+
+// SaveSnapshot records the current branch, HEAD and local changes of every
+// repository in file. Local changes are kept as a stash entry (`git stash
+// create` + `git stash store`), leaving the worktree untouched; untracked files
+// are not part of it.
+func (runner *runner) SaveSnapshot(name, file string, repos []string) int {
+	states := make([]repositorySnapshot, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		state := repositorySnapshot{Path: repo}
+		state.Branch, _ = runner.git(ctx, repo, "symbolic-ref", "--short", "--quiet", "HEAD")
+		if state.Head, errs[i] = runner.git(ctx, repo, "rev-parse", "--verify", "HEAD"); errs[i] != nil {
+			return
+		}
+		if state.Stash, errs[i] = runner.git(ctx, repo, "stash", "create"); errs[i] != nil {
+			return
+		}
+		if state.Stash != "" {
+			if _, errs[i] = runner.git(ctx, repo, "stash", "store", "--message", "parallel-git-repo snapshot "+name, state.Stash); errs[i] != nil {
+				return
+			}
+		}
+		states[i] = state
+	})
+
+	failures := 0
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+		}
+	}
+	if failures > 0 {
+		fmt.Fprintf(runner.writer, "\nSnapshot %q not saved.\n", name)
+		return failures
+	}
+
+	content, err := json.MarshalIndent(snapshot{Name: name, Created: time.Now(), Repositories: states}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(file, content, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, state := range states {
+		fmt.Fprintf(runner.writer, "%s: %s %s\n", filepath.Base(state.Path), ok, describeSnapshot(state))
+	}
+	fmt.Fprintf(runner.writer, "\nSnapshot %q saved to %s\n", name, file)
+	return 0
+}
+
+// RestoreSnapshot puts every repository of the snapshot in file back on its
+// branch at the recorded commit, then reapplies the recorded local changes.
+// Repositories with local changes are refused unless force is set, since
+// restoring discards them.
+func (runner *runner) RestoreSnapshot(file string, force, partial bool) int {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var saved snapshot
+	if err := json.Unmarshal(content, &saved); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid snapshot %s\n%v\n", file, err)
+		return 1
+	}
+
+	states := make(map[string]repositorySnapshot)
+	repos := make([]string, 0, len(saved.Repositories))
+	for _, state := range saved.Repositories {
+		states[state.Path] = state
+		repos = append(repos, state.Path)
+	}
+	repos = runner.skipProtected(repos)
+
+	return runner.apply(operation{
+		check: func(ctx context.Context, repo string) error {
+			state := states[repo]
+			if _, err := runner.git(ctx, repo, "cat-file", "-e", state.Head+"^{commit}"); err != nil {
+				return fmt.Errorf("commit %.7s no longer exists", state.Head)
+			}
+			if state.Stash != "" {
+				if _, err := runner.git(ctx, repo, "cat-file", "-e", state.Stash+"^{commit}"); err != nil {
+					return fmt.Errorf("stash %.7s no longer exists", state.Stash)
+				}
+			}
+			if force {
+				return nil
+			}
+			if err := runner.checkClean(ctx, repo); err != nil {
+				return errors.New("worktree has local changes that restoring would discard, use --force to discard them")
+			}
+			return nil
+		},
+		act: func(ctx context.Context, repo string) error {
+			state := states[repo]
+			var err error
+			if state.Branch == "" {
+				_, err = runner.git(ctx, repo, "switch", "--quiet", "--discard-changes", "--detach", state.Head)
+			} else {
+				// -C moves the branch back to the recorded commit, recreating it if
+				// it was deleted in the meantime.
+				_, err = runner.git(ctx, repo, "switch", "--quiet", "--discard-changes", "-C", state.Branch, state.Head)
+			}
+			if err != nil {
+				return err
+			}
+			if state.Stash != "" {
+				_, err = runner.git(ctx, repo, "stash", "apply", "--index", "--quiet", state.Stash)
+			}
+			return err
+		},
+	}, repos, partial)
+}
+
+func describeSnapshot(state repositorySnapshot) string {
+	description := fmt.Sprintf("%.7s", state.Head)
+	if state.Branch != "" {
+		description = state.Branch + " at " + description
+	}
+	if state.Stash != "" {
+		description += " with local changes"
+	}
+	return description
+}
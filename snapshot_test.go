@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotSaveAndRestore(t *testing.T) {
+	repo := gitRepository(t, "maven-color", map[string]string{"a.txt": "a"})
+	head := git(t, repo, "rev-parse", "HEAD")
+	os.WriteFile(filepath.Join(repo, "a.txt"), []byte("local change"), 0644)
+
+	runner, output := testRunner(nil, staticRepositories{})
+	file := filepath.Join(t.TempDir(), "before-merge.json")
+
+	if failures := runner.SaveSnapshot("before-merge", file, []string{repo}); failures != 0 {
+		t.Fatalf("got %d failures, want 0: %s", failures, output)
+	}
+
+	// A bulk operation gone wrong: local changes put aside, a commit on main
+	// and another branch checked out.
+	git(t, repo, "stash", "--quiet")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "bad merge")
+	git(t, repo, "switch", "-q", "-c", "elsewhere")
+	os.WriteFile(filepath.Join(repo, "a.txt"), []byte("dirty again"), 0644)
+
+	if failures := runner.RestoreSnapshot(file, false, false); failures != 1 {
+		t.Errorf("got %d failures over local changes, want 1", failures)
+	}
+
+	if failures := runner.RestoreSnapshot(file, true, false); failures != 0 {
+		t.Fatalf("got %d failures, want 0: %s", failures, output)
+	}
+	assertEqual(t, git(t, repo, "branch", "--show-current"), "main")
+	assertEqual(t, git(t, repo, "rev-parse", "HEAD"), head)
+	content, _ := os.ReadFile(filepath.Join(repo, "a.txt"))
+	assertEqual(t, string(content), "local change")
+}
+
+func TestSnapshotFileRejectsPaths(t *testing.T) {
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		if _, err := snapshotFile(name); err == nil {
+			t.Errorf("expected an error for snapshot name %q", name)
+		}
+	}
+}
+
+func TestSnapshotSaveSkipsReadonlyRepositories(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	app := gitRepository(t, "app", map[string]string{"a.txt": "a"})
+	mirror := gitRepository(t, "mirror", map[string]string{"a.txt": "a"})
+	for _, repo := range []string{app, mirror} {
+		os.WriteFile(filepath.Join(repo, "a.txt"), []byte("local change"), 0644)
+	}
+	file := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(file, []byte(`
+[repositories]
+  default = ["`+app+`", { path = "`+mirror+`", readonly = true }]
+`), 0644)
+
+	if failures := snapshotCommand(newConfiguration(file), []string{"save", "before-merge"}, "default"); failures != 0 {
+		t.Fatalf("got %d failures, want 0", failures)
+	}
+
+	assertEqual(t, git(t, mirror, "stash", "list"), "")
+	saved, _ := snapshotFile("before-merge")
+	content, _ := os.ReadFile(saved)
+	if !strings.Contains(string(content), app) || strings.Contains(string(content), mirror) {
+		t.Errorf("expected only app in the snapshot, got %s", content)
+	}
+}
+
+func TestSnapshotsAreStoredInXDGStateHome(t *testing.T) {
+	saved := home
+	defer func() { home = saved }()
+	home = "/home/user"
+
+	t.Setenv("XDG_STATE_HOME", "/state")
+	file, _ := snapshotFile("before-merge")
+	assertEqual(t, file, "/state/parallel-git-repo/snapshots/before-merge.json")
+
+	t.Setenv("XDG_STATE_HOME", "")
+	file, _ = snapshotFile("before-merge")
+	assertEqual(t, file, "/home/user/.local/state/parallel-git-repo/snapshots/before-merge.json")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// syncCommand implements the built-in `sync`.
+func syncCommand(config *configuration, group string) int {
+	runner := configuredRunner(nil, config)
+	runner.commandNames = []string{"sync"}
+	runner.writes = true
+	return runner.Sync(group)
+}
+
+// Sync checks out each repository's default branch and fast-forwards it to its
+// upstream. A configured `git checkout master && git pull` can only name one
+// branch for every repository.
+func (runner *runner) Sync(group string) int {
+	repos, err := runner.selected(group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	branches := make([]string, len(repos))
+	errs := make([]error, len(repos))
+	runner.each(repos, func(ctx context.Context, i int, repo string) {
+		if branches[i], errs[i] = runner.defaultBranch(ctx, repo); errs[i] != nil {
+			return
+		}
+		if _, errs[i] = runner.git(ctx, repo, "switch", "--quiet", branches[i]); errs[i] != nil {
+			return
+		}
+		_, errs[i] = runner.git(ctx, repo, "pull", "--ff-only", "--quiet")
+	})
+
+	failures := 0
+	for i, repo := range repos {
+		if errs[i] != nil {
+			failures++
+			fmt.Fprintf(runner.writer, "%s: %s\n  %v\n", filepath.Base(repo), ko, errs[i])
+			continue
+		}
+		fmt.Fprintf(runner.writer, "%s: %s %s\n", filepath.Base(repo), ok, branches[i])
+	}
+	return failures
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncChecksOutAndFastForwardsTheDefaultBranch(t *testing.T) {
+	origin := gitRepository(t, "origin", nil)
+	git(t, origin, "switch", "-q", "-c", "develop")
+	repo := filepath.Join(t.TempDir(), "maven-color")
+	git(t, origin, "clone", "-q", origin, repo)
+	git(t, repo, "switch", "-q", "-c", "feature")
+	git(t, origin, "commit", "-q", "--allow-empty", "-m", "upstream change")
+
+	runner, output := testRunner(nil, staticRepositories{"default": {repo}})
+
+	if failures := runner.Sync("default"); failures != 0 {
+		t.Fatalf("got %d failures, want 0: %s", failures, output)
+	}
+	assertEqual(t, git(t, repo, "branch", "--show-current"), "develop")
+	assertEqual(t, git(t, repo, "rev-parse", "HEAD"), git(t, origin, "rev-parse", "HEAD"))
+	assertEqual(t, output.String(), "maven-color: "+ok+" develop\n")
+}